@@ -75,6 +75,8 @@ var (
 	cancelExist            bool                     //キャンセルを表示するならtrue
 	cancelString           string                   //キャンセルの項目の名前
 	cancelExe              func()                   //キャンセルを指定した時に実行
+	leftExe                func()                   //左キーを押したときに実行
+	rightExe               func()                   //右キーを押したときに実行
 	noChoiFg               termbox.Attribute        //選択肢の文字
 	noChoiBg               termbox.Attribute        //選択肢のバックグラウンド
 	choiFg                 termbox.Attribute        //選択中の文字
@@ -96,6 +98,8 @@ func initChoiceList() {
 	cancelExist = false
 	cancelString = "キャンセル"
 	cancelExe = nil
+	leftExe = func() {}
+	rightExe = func() {}
 	noChoiFg = defaultFg
 	noChoiBg = defaultBg
 	choiFg = termbox.ColorBlack
@@ -105,7 +109,7 @@ func initChoiceList() {
 	md = moveDown
 
 	//キーを設定
-	SetInputFunction(mu, md, func() {}, func() {}, cancelExe, selectExecute, func() {}, func() {})
+	setChoiceListKeys()
 }
 
 //setPattern リストパターンを設定
@@ -117,7 +121,22 @@ func setPattern(p ChoiceListDisplayPattern) {
 func setExecute(e func(int)) {
 	choiExe = e
 	//キーを設定
-	SetInputFunction(mu, md, func() {}, func() {}, cancelExe, selectExecute, func() {}, func() {})
+	setChoiceListKeys()
+}
+
+//setLeftRightExecute 左右キーを押したときに実行される関数を設定
+func setLeftRightExecute(left, right func()) {
+	leftExe = left
+	rightExe = right
+	//キーを設定
+	setChoiceListKeys()
+}
+
+//setCurrentCursor 選択中の項目を設定
+func setCurrentCursor(index int) {
+	if index >= 0 && index < listLen() {
+		currentCursor = index
+	}
 }
 
 //setSection 描画範囲を設定
@@ -156,7 +175,19 @@ func cancelSetting(exist bool, str string, exe func()) {
 	cancelString = str
 	cancelExe = exe
 	//キーを設定
-	SetInputFunction(mu, md, func() {}, func() {}, cancelExe, selectExecute, func() {}, func() {})
+	setChoiceListKeys()
+}
+
+//setChoiceListKeys 選択肢を操作するキーを設定
+func setChoiceListKeys() {
+	SetKeyActions(map[keyAction]func(){
+		actionUp:     mu,
+		actionDown:   md,
+		actionLeft:   leftExe,
+		actionRight:  rightExe,
+		actionCancel: cancelExe,
+		actionSelect: selectExecute,
+	})
 }
 
 //listLen リストの要素数を返す(キャンセルも項目数に含む)
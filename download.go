@@ -0,0 +1,387 @@
+package main
+
+//小説のダウンロードとローカル保存
+//保存先はデータディレクトリ以下のnovels/{小説のID}/で、次の構成で保存する
+//小説のIDはサイトごとに形式が異なり重複しないので、サイトでディレクトリを分けない
+//	store.json          保存形式のバージョン、小説情報、各話の一覧
+//	episodes/{話数}.json 各話の前書き、本文、後書きを段落に分けたもの
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const storeLayoutVersion = 3       //保存形式のバージョン。形式を変えたら上げる
+const novelsDirName = "novels"     //小説を保存するディレクトリ名
+const storeFileName = "store.json" //小説情報と各話の一覧を保存するファイル名
+const episodesDirName = "episodes" //各話の本文を保存するディレクトリ名
+
+var novelDownloader *downloader //小説のダウンロードを行う
+
+//保存された小説情報と各話の一覧を読み書きするための中間構造体
+type storejson struct {
+	Version int              `json:"version"` //保存形式のバージョン
+	Info    libraryNoveljson `json:"info"`
+	Index   []storyjson      `json:"index"`
+}
+
+//各話の情報を保存するための中間構造体
+type storyjson struct {
+	Number       int    `json:"number"`
+	SubTitle     string `json:"subtitle"`
+	ChapterTitle string `json:"chaptertitle"`
+	UpdatedAt    string `json:"updatedat"`
+}
+
+//各話の前書き、本文、後書きを保存するための中間構造体
+type episodejson struct {
+	Preface   []paragraphjson `json:"preface"`
+	Honbun    []paragraphjson `json:"honbun"`
+	Afterword []paragraphjson `json:"afterword"`
+}
+
+//段落を保存するための中間構造体
+type paragraphjson struct {
+	Blank int        `json:"blank,omitempty"`
+	Spans []spanjson `json:"spans,omitempty"`
+}
+
+//段落を構成する要素を保存するための中間構造体
+type spanjson struct {
+	Kind    spanKind `json:"kind"`
+	Text    string   `json:"text"`
+	Reading string   `json:"reading,omitempty"`
+	Src     string   `json:"src,omitempty"`
+}
+
+//errStoreVersion 保存形式のバージョンが対応していない
+var errStoreVersion = errors.New("保存形式のバージョンが対応していません")
+
+//storeDir 小説を保存するディレクトリのパスを返す
+func storeDir(ncode string) string {
+	return filepath.Join(dataDir(), novelsDirName, ncode)
+}
+
+//episodePath 各話の本文を保存するファイルのパスを返す
+func episodePath(ncode string, storyNum int) string {
+	return filepath.Join(storeDir(ncode), episodesDirName, strconv.Itoa(storyNum)+".json")
+}
+
+//hasLocalCopy 小説がローカルに保存されているならtrue
+func hasLocalCopy(ncode string) bool {
+	_, err := loadIndex(ncode)
+	return err == nil
+}
+
+//saveIndex 小説情報と各話の一覧を保存する
+func saveIndex(info *novelinformation, stories []storyInformation) error {
+	err := os.MkdirAll(filepath.Join(storeDir(info.ncode), episodesDirName), 0755)
+	if err != nil {
+		return err
+	}
+	intermediatestore := storejson{
+		storeLayoutVersion,
+		newLibraryNoveljson(info),
+		[]storyjson{},
+	}
+	for _, s := range stories {
+		intermediatestore.Index = append(intermediatestore.Index, storyjson{s.number, s.subTitle, s.chapterTitle, s.updatedAt})
+	}
+	b, err := json.MarshalIndent(intermediatestore, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(storeDir(info.ncode), storeFileName), b)
+}
+
+//loadIndex 保存されている各話の一覧を読み込む
+func loadIndex(ncode string) ([]storyInformation, error) {
+	b, err := os.ReadFile(filepath.Join(storeDir(ncode), storeFileName))
+	if err != nil {
+		return nil, err
+	}
+	var intermediatestore storejson
+	err = json.Unmarshal(b, &intermediatestore)
+	if err != nil {
+		return nil, err
+	}
+	if intermediatestore.Version != storeLayoutVersion {
+		return nil, errStoreVersion
+	}
+	stories := []storyInformation{}
+	for _, s := range intermediatestore.Index {
+		stories = append(stories, storyInformation{s.Number, s.SubTitle, s.ChapterTitle, s.UpdatedAt})
+	}
+	return stories, nil
+}
+
+//saveEpisode 各話の前書き、本文、後書きを保存する
+func saveEpisode(ncode string, storyNum int, story *episode) error {
+	b, err := json.Marshal(episodejson{
+		newParagraphjsons(story.preface),
+		newParagraphjsons(story.honbun),
+		newParagraphjsons(story.afterword),
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(episodePath(ncode, storyNum), b)
+}
+
+//loadEpisode 保存されている各話の前書き、本文、後書きを読み込む
+func loadEpisode(ncode string, storyNum int) (*episode, error) {
+	b, err := os.ReadFile(episodePath(ncode, storyNum))
+	if err != nil {
+		return nil, err
+	}
+	var intermediateepisode episodejson
+	err = json.Unmarshal(b, &intermediateepisode)
+	if err != nil {
+		return nil, err
+	}
+	return &episode{
+		paragraphsFromjson(intermediateepisode.Preface),
+		paragraphsFromjson(intermediateepisode.Honbun),
+		paragraphsFromjson(intermediateepisode.Afterword),
+	}, nil
+}
+
+//newParagraphjsons 段落を保存用の中間構造体に変換する。nilはnilのままにする
+func newParagraphjsons(paragraphs []paragraph) []paragraphjson {
+	if paragraphs == nil {
+		return nil
+	}
+	ps := []paragraphjson{}
+	for _, p := range paragraphs {
+		spans := []spanjson{}
+		for _, s := range p.spans {
+			spans = append(spans, spanjson{s.kind, s.text, s.reading, s.src})
+		}
+		ps = append(ps, paragraphjson{p.blank, spans})
+	}
+	return ps
+}
+
+//paragraphsFromjson 保存用の中間構造体を段落に変換する。nilはnilのままにする
+func paragraphsFromjson(ps []paragraphjson) []paragraph {
+	if ps == nil {
+		return nil
+	}
+	paragraphs := []paragraph{}
+	for _, p := range ps {
+		var spans []span
+		for _, s := range p.Spans {
+			spans = append(spans, span{s.Kind, s.Text, s.Reading, s.Src})
+		}
+		paragraphs = append(paragraphs, paragraph{p.Blank, spans})
+	}
+	return paragraphs
+}
+
+//hasEpisode 各話の本文が保存されているならtrue
+func hasEpisode(ncode string, storyNum int) bool {
+	_, err := os.Stat(episodePath(ncode, storyNum))
+	return err == nil
+}
+
+//countEpisodes 保存されている話数を返す
+func countEpisodes(ncode string) int {
+	files, err := os.ReadDir(filepath.Join(storeDir(ncode), episodesDirName))
+	if err != nil {
+		return 0
+	}
+	return len(files)
+}
+
+//removeLocalCopy ローカルに保存された小説を削除する
+func removeLocalCopy(ncode string) error {
+	return os.RemoveAll(storeDir(ncode))
+}
+
+//revisedStories 前回の目次と今回の目次を比べて、掲載日時が変わった話数を返す
+func revisedStories(oldStories, newStories []storyInformation) map[int]bool {
+	oldUpdatedAt := map[int]string{}
+	for _, s := range oldStories {
+		oldUpdatedAt[s.number] = s.updatedAt
+	}
+	revised := map[int]bool{}
+	for _, s := range newStories {
+		old, ok := oldUpdatedAt[s.number]
+		if ok && old != "" && old != s.updatedAt {
+			revised[s.number] = true
+		}
+	}
+	return revised
+}
+
+//writeFileAtomic 書き込み途中で終了してもファイルが壊れないように一時ファイルに書いてから置き換える
+func writeFileAtomic(path string, b []byte) error {
+	err := os.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//downloader 小説のダウンロードを一冊ずつ順番に行う
+//ダウンロード待ちの一覧は入力を処理するgoroutineだけが触るので、追加しても入力の処理を止めない
+//ダウンロードは別のgoroutineで行い、小説の一覧の変更と保存は終わった後に入力を処理するgoroutineで行う
+type downloader struct {
+	waiting []downloadJob //ダウンロード待ちの小説
+	current *downloadJob  //ダウンロード中の小説。無ければnil
+	mu      sync.Mutex
+	status  string //現在の状況
+}
+
+//downloadJob ダウンロード待ちの小説
+type downloadJob struct {
+	info     *novelinformation  //一覧の小説。ダウンロードが終わった後に入力を処理するgoroutineで更新する
+	snapshot novelinformation   //ダウンロード中に読む小説情報の写し
+	cancel   context.CancelFunc //ダウンロード中の取得を中断する
+	removed  bool               //ダウンロード中に一覧から削除されたならtrue
+}
+
+//newDownloader 作成する
+func newDownloader() *downloader {
+	return &downloader{
+		waiting: []downloadJob{},
+		status:  "",
+	}
+}
+
+//download 小説をダウンロード待ちに追加する。入力を処理するgoroutineで呼ぶ
+//既にダウンロード待ちの小説は追加せず、小説情報の写しだけを新しくする。ダウンロード中の小説は終わった後にもう一度ダウンロードする
+func (d *downloader) download(info *novelinformation) {
+	d.setStatus(info.title + "のダウンロード待ち")
+	topView.downloading = true
+	for i, job := range d.waiting {
+		if job.info.ncode == info.ncode {
+			d.waiting[i] = downloadJob{info, *info, nil, false}
+			return
+		}
+	}
+	d.waiting = append(d.waiting, downloadJob{info, *info, nil, false})
+	d.startNext()
+}
+
+//startNext ダウンロード中の小説が無ければ、次のダウンロード待ちの小説を別のgoroutineでダウンロードする
+func (d *downloader) startNext() {
+	if d.current != nil || len(d.waiting) == 0 {
+		return
+	}
+	job := d.waiting[0]
+	d.waiting = d.waiting[1:]
+	ctx, cancel := context.WithCancel(appContext)
+	job.cancel = cancel
+	d.current = &job
+	go func() {
+		err := d.downloadNovel(ctx, &job.snapshot)
+		runOnUI(func() {
+			d.finish(&job, err)
+		})
+	}()
+}
+
+//remove 一覧から削除する小説のダウンロードをやめる。入力を処理するgoroutineで呼ぶ
+//ダウンロード待ちなら取り除き、ダウンロード中なら中断して、終わった後に保存した本文を削除する
+func (d *downloader) remove(ncode string) {
+	for i, job := range d.waiting {
+		if job.info.ncode == ncode {
+			d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+			break
+		}
+	}
+	if d.current != nil && d.current.info.ncode == ncode {
+		d.current.removed = true
+		d.current.cancel()
+	}
+	topView.downloading = d.current != nil || len(d.waiting) > 0
+}
+
+//Status 現在の状況を返す
+func (d *downloader) Status() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+func (d *downloader) setStatus(s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = s
+}
+
+//finish ダウンロードの結果を小説の一覧に反映して保存し、次のダウンロードを始める。入力を処理するgoroutineで呼ぶ
+func (d *downloader) finish(job *downloadJob, err error) {
+	job.cancel()
+	d.current = nil
+	topView.downloading = len(d.waiting) > 0
+	defer d.startNext()
+	info := job.info
+	if job.removed {
+		//削除した後に保存された本文が残らないようにする
+		if err := removeLocalCopy(info.ncode); err != nil {
+			d.setStatus(info.title + "の保存した本文の削除に失敗しました：" + err.Error())
+			return
+		}
+		d.setStatus(info.title + "のダウンロードを中断しました")
+		return
+	}
+	if err != nil {
+		d.setStatus(info.title + "のダウンロードに失敗しました：" + err.Error())
+		return
+	}
+	info.hasupdate = false //更新分も含めて保存できた
+	if err = novelLibrary.save(); err != nil {
+		d.setStatus("小説の一覧の保存に失敗しました：" + err.Error())
+		return
+	}
+	d.setStatus(info.title + "のダウンロードが完了しました")
+}
+
+//downloadNovel 各話の一覧と、まだ保存していない話、改稿された話を取得して保存する
+func (d *downloader) downloadNovel(ctx context.Context, info *novelinformation) error {
+	novel := newStoredNovel()
+	novel.init(info)
+	d.setStatus(info.title + "の目次を取得中")
+	stories, err := novel.fetchIndexByChapter(ctx)
+	if err != nil {
+		return err
+	}
+	//前回保存した目次と比べて改稿された話を探す
+	revised := map[int]bool{}
+	oldStories, err := loadIndex(info.ncode)
+	if err == nil {
+		revised = revisedStories(oldStories, stories)
+	} else if err == errStoreVersion {
+		//古い形式で保存されているので全て取得し直す
+		if err = removeLocalCopy(info.ncode); err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(filepath.Join(storeDir(info.ncode), episodesDirName), 0755)
+	if err != nil {
+		return err
+	}
+	for i, s := range stories {
+		if hasEpisode(info.ncode, s.number) && !revised[s.number] {
+			continue //保存済み
+		}
+		d.setStatus(info.title + "をダウンロード中 " + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(stories)))
+		story, err := novel.fetchStory(ctx, s.number)
+		if err != nil {
+			return errors.New(strconv.Itoa(s.number) + "話目を取得できませんでした：" + err.Error())
+		}
+		err = saveEpisode(info.ncode, s.number, story)
+		if err != nil {
+			return err
+		}
+	}
+	//目次は全ての話を保存してから書き込む。途中で失敗した時に新しい掲載日時を書き込むと、次のダウンロードで改稿された話を見つけられなくなる
+	return saveIndex(info, stories)
+}
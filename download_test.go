@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+//fakeSource 決まった目次と本文を返すサイト。failに含まれる話の取得は失敗する
+type fakeSource struct {
+	stories []storyInformation
+	fail    map[int]bool
+	fetched []int         //本文を取得した話の番号
+	block   chan struct{} //nilでなければ、閉じるまで目次の取得を待たせる
+}
+
+func (site *fakeSource) sourceID() string { return "fake" }
+func (site *fakeSource) siteName() string { return "テスト" }
+func (site *fakeSource) search(ctx context.Context, filter url.Values, st, lim int) (int, []searchResult, error) {
+	return 0, nil, nil
+}
+func (site *fakeSource) fetchInformations(ctx context.Context, ncodes []string) (map[string]*novelinformation, error) {
+	return map[string]*novelinformation{}, nil
+}
+func (site *fakeSource) fetchIndex(ctx context.Context, ncode string) ([]storyInformation, error) {
+	if site.block != nil {
+		<-site.block
+	}
+	return site.stories, nil
+}
+func (site *fakeSource) fetchStory(ctx context.Context, ncode string, storyNum int) (*episode, error) {
+	site.fetched = append(site.fetched, storyNum)
+	if site.fail[storyNum] {
+		return nil, errors.New("取得に失敗")
+	}
+	return &episode{nil, []paragraph{{0, []span{{textSpan, "本文", "", ""}}}}, nil}, nil
+}
+func (site *fakeSource) fetchShortStory(ctx context.Context, ncode string) (*episode, error) {
+	return site.fetchStory(ctx, ncode, 1)
+}
+func (site *fakeSource) searchOrders() []searchOrder { return nil }
+func (site *fakeSource) genreFilters() []genreFilter { return nil }
+
+func TestDownloadNovelKeepsIndexUntilRevisedEpisodesAreSaved(t *testing.T) {
+	useTempDataDir(t)
+	site := &fakeSource{stories: []storyInformation{
+		{1, "一話", "", "2020/01/01 00:00"},
+		{2, "二話", "", "2020/01/02 00:00"},
+	}}
+	info := &novelinformation{site: site, ncode: "n0001a", title: "テスト", isrensai: true}
+	d := &downloader{}
+	if err := d.downloadNovel(context.Background(), info); err != nil {
+		t.Fatal(err)
+	}
+
+	//二話が改稿されたが、取得に失敗した
+	site.stories = []storyInformation{
+		{1, "一話", "", "2020/01/01 00:00"},
+		{2, "二話", "", "2020/02/01 00:00"},
+	}
+	site.fail = map[int]bool{2: true}
+	site.fetched = nil
+	if err := d.downloadNovel(context.Background(), info); err == nil {
+		t.Fatal("取得に失敗したのにエラーにならない")
+	}
+	stories, err := loadIndex(info.ncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stories[1].updatedAt != "2020/01/02 00:00" {
+		t.Errorf("保存できなかった話の掲載日時が書き換えられた: %q", stories[1].updatedAt)
+	}
+
+	//次のダウンロードで改稿された話を取得し直す
+	site.fail = nil
+	site.fetched = nil
+	if err := d.downloadNovel(context.Background(), info); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(site.fetched, want) {
+		t.Errorf("取得した話 = %v, want %v", site.fetched, want)
+	}
+	if stories, _ := loadIndex(info.ncode); stories[1].updatedAt != "2020/02/01 00:00" {
+		t.Errorf("改稿された話の掲載日時 = %q, want %q", stories[1].updatedAt, "2020/02/01 00:00")
+	}
+}
+
+func TestDownloaderQueue(t *testing.T) {
+	useTempDataDir(t)
+	topView = &topview{}
+	site := &fakeSource{block: make(chan struct{})}
+	d := newDownloader()
+	infos := []*novelinformation{}
+	for i := 0; i < 200; i++ { //入力を処理するgoroutineを止めないように、ダウンロード待ちの数に上限は無い
+		info := &novelinformation{site: site, ncode: "n" + strconv.Itoa(i), title: "テスト", isrensai: true}
+		infos = append(infos, info)
+		d.download(info)
+	}
+	d.download(infos[1]) //ダウンロード待ちの小説は二度追加しない
+	if d.current == nil || d.current.info != infos[0] {
+		t.Fatal("最初の小説をダウンロードしていない")
+	}
+	if len(d.waiting) != 199 {
+		t.Errorf("ダウンロード待ちの数 = %d, want 199", len(d.waiting))
+	}
+	if !topView.downloading {
+		t.Error("ダウンロード中になっていない")
+	}
+
+	//ダウンロード待ちを空にしてから、ダウンロード中の小説を終わらせる
+	d.waiting = nil
+	close(site.block)
+	(<-uiTasks)()
+	if d.current != nil || topView.downloading {
+		t.Error("ダウンロードが終わっていない")
+	}
+}
+
+func TestDownloaderRemove(t *testing.T) {
+	useTempDataDir(t)
+	topView = &topview{}
+	site := &fakeSource{block: make(chan struct{})}
+	d := newDownloader()
+	running := &novelinformation{site: site, ncode: "n0001a", title: "ダウンロード中", isrensai: true}
+	waiting := &novelinformation{site: site, ncode: "n0002a", title: "ダウンロード待ち", isrensai: true}
+	d.download(running)
+	d.download(waiting)
+
+	d.remove(waiting.ncode)
+	if len(d.waiting) != 0 {
+		t.Errorf("ダウンロード待ちの数 = %d, want 0", len(d.waiting))
+	}
+
+	//ダウンロード中の小説は中断して、終わった後に保存された本文を削除する
+	d.remove(running.ncode)
+	if err := os.MkdirAll(storeDir(running.ncode), 0755); err != nil {
+		t.Fatal(err)
+	}
+	close(site.block)
+	(<-uiTasks)()
+	if _, err := os.Stat(storeDir(running.ncode)); !os.IsNotExist(err) {
+		t.Errorf("削除した小説の本文が残っている: %v", err)
+	}
+	if d.current != nil || topView.downloading {
+		t.Error("ダウンロードが終わっていない")
+	}
+}
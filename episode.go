@@ -0,0 +1,356 @@
+package main
+
+//各話の本文の構造
+//サイトのHTMLを段落と、段落を構成する文字列、ルビ、傍点、挿絵に分けて保持する
+//表示する時に設定に合わせて文字列に変換するので、解析の段階ではルビなどを文字列にしない
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/net/html"
+)
+
+//episode 各話。前書きと後書きが無い話ではnilになる
+type episode struct {
+	preface   []paragraph //前書き
+	honbun    []paragraph //本文
+	afterword []paragraph //後書き
+}
+
+//paragraph 段落。画面では一行(折り返しを除く)として表示する
+type paragraph struct {
+	blank int    //連続する空行の数。0なら文章の段落
+	spans []span //段落を構成する要素
+}
+
+//spanKind 段落を構成する要素の種類
+type spanKind int
+
+const (
+	//textSpan 文字列
+	textSpan spanKind = iota
+	//rubySpan ルビ付きの文字列
+	rubySpan
+	//emphasisSpan 傍点付きの文字列
+	emphasisSpan
+	//imageSpan 挿絵
+	imageSpan
+)
+
+//span 段落を構成する要素
+type span struct {
+	kind    spanKind
+	text    string //文字列。ルビでは親文字、挿絵では説明
+	reading string //ルビの読み
+	src     string //挿絵のURL
+}
+
+//emphasisDots 傍点として使われる文字。なろうでは傍点を一文字ずつのルビで表す
+const emphasisDots = "・﹅●◦"
+
+//parseParagraphs 本文などの要素を段落に分ける。p要素とbr要素で段落を区切り、要素が無ければnilを返す
+func parseParagraphs(section *goquery.Selection) []paragraph {
+	if section.Length() == 0 {
+		return nil
+	}
+	parser := &paragraphParser{paragraphs: []paragraph{}}
+	for _, n := range section.Nodes {
+		parser.children(n)
+	}
+	if len(parser.spans) > 0 {
+		parser.endLine()
+	}
+	return parser.paragraphs
+}
+
+//paragraphParser HTMLを順に辿って段落を組み立てる
+type paragraphParser struct {
+	paragraphs []paragraph //組み立て終わった段落
+	spans      []span      //組み立て中の段落
+	lines      int         //区切った段落の数(空行を含む)
+}
+
+//children 子要素を順に解析する
+func (parser *paragraphParser) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		parser.node(c)
+	}
+}
+
+//node 要素の種類に応じて段落に加える
+func (parser *paragraphParser) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		//HTMLの整形のための改行や字下げは段落の区切りや文字列としない
+		text := strings.NewReplacer("\r", "", "\n", "").Replace(n.Data)
+		if len(parser.spans) == 0 && strings.Trim(text, " \t") == "" {
+			return
+		}
+		parser.addText(text)
+	case html.ElementNode:
+		switch n.Data {
+		case "p", "div":
+			if len(parser.spans) > 0 {
+				parser.endLine()
+			}
+			start := parser.lines
+			parser.children(n)
+			if len(parser.spans) > 0 || parser.lines == start {
+				parser.endLine() //中身が無いp要素は空行
+			}
+		case "br":
+			parser.endLine()
+		case "ruby":
+			parser.addRuby(n)
+		case "img":
+			parser.spans = append(parser.spans, span{imageSpan, attr(n, "alt"), "", absoluteURL(attr(n, "src"))})
+		case "rp", "rt", "script", "style":
+			//ルビ以外に現れた読みと、表示しない要素は読み飛ばす
+		default:
+			if strings.Contains(attr(n, "class"), "emphasisDots") {
+				//傍点の要素
+				parser.spans = append(parser.spans, span{emphasisSpan, nodeText(n), "", ""})
+				return
+			}
+			parser.children(n)
+		}
+	}
+}
+
+//addText 文字列を加える。直前も文字列なら繋げる
+func (parser *paragraphParser) addText(text string) {
+	if text == "" {
+		return
+	}
+	if last := len(parser.spans) - 1; last >= 0 && parser.spans[last].kind == textSpan {
+		parser.spans[last].text += text
+		return
+	}
+	parser.spans = append(parser.spans, span{textSpan, text, "", ""})
+}
+
+//addRuby ルビを加える。読みが全て傍点の文字なら傍点として加える
+func (parser *paragraphParser) addRuby(n *html.Node) {
+	var base, reading strings.Builder
+	var walk func(*html.Node, bool)
+	walk = func(n *html.Node, inRt bool) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch {
+			case c.Type == html.TextNode && inRt:
+				reading.WriteString(c.Data)
+			case c.Type == html.TextNode:
+				base.WriteString(c.Data)
+			case c.Type == html.ElementNode && c.Data == "rp":
+				//ルビの括弧は表示する時に付ける
+			default:
+				walk(c, inRt || c.Data == "rt")
+			}
+		}
+	}
+	walk(n, false)
+	baseText := strings.Trim(base.String(), " \t\r\n")
+	readingText := strings.Trim(reading.String(), " \t\r\n")
+	if readingText != "" && strings.Trim(readingText, emphasisDots) == "" && utf8.RuneCountInString(readingText) == utf8.RuneCountInString(baseText) {
+		//傍点は一文字ずつ別のルビになっているので、直前の傍点と繋げる
+		if last := len(parser.spans) - 1; last >= 0 && parser.spans[last].kind == emphasisSpan {
+			parser.spans[last].text += baseText
+			return
+		}
+		parser.spans = append(parser.spans, span{emphasisSpan, baseText, "", ""})
+		return
+	}
+	parser.spans = append(parser.spans, span{rubySpan, baseText, readingText, ""})
+}
+
+//endLine 組み立て中の段落を区切る。中身が無ければ空行とし、空行が続く場合はまとめる
+func (parser *paragraphParser) endLine() {
+	parser.lines++
+	if len(parser.spans) == 0 {
+		if last := len(parser.paragraphs) - 1; last >= 0 && parser.paragraphs[last].blank > 0 {
+			parser.paragraphs[last].blank++
+			return
+		}
+		parser.paragraphs = append(parser.paragraphs, paragraph{1, nil})
+		return
+	}
+	parser.paragraphs = append(parser.paragraphs, paragraph{0, parser.spans})
+	parser.spans = nil
+}
+
+//attr 要素の属性を返す。無ければ空文字列を返す
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+//nodeText 要素に含まれる文字列を返す
+func nodeText(n *html.Node) string {
+	return goquery.NewDocumentFromNode(n).Text()
+}
+
+//absoluteURL スキームが省略されたURLにhttpsを付ける
+func absoluteURL(src string) string {
+	if strings.HasPrefix(src, "//") {
+		return "https:" + src
+	}
+	return src
+}
+
+//paragraphLines 段落をルビの表示方法に合わせて表示する行に変換する
+//上の行に読みを表示する場合は幅wで折り返した行を改行で繋げて返し、それ以外は折り返さずに返す。どちらも一段落が一行になる
+//unitsは各行の折り返しの単位で、折り返さずに返した行だけに作る。それ以外の行はnilになる
+func paragraphLines(paragraphs []paragraph, style rubyStyle, w int) (lines []string, units [][]foldUnit) {
+	lines = []string{}
+	units = [][]foldUnit{}
+	for _, p := range paragraphs {
+		if p.blank > 0 {
+			for i := 0; i < p.blank; i++ {
+				lines = append(lines, "")
+				units = append(units, nil)
+			}
+			continue
+		}
+		if style.mode == twoRowRuby {
+			lines = append(lines, strings.Join(twoRowLines(p, w), "\n"))
+			units = append(units, nil)
+			continue
+		}
+		pu := paragraphFoldUnits(p, style)
+		lines = append(lines, joinUnits(pu))
+		units = append(units, pu)
+	}
+	return lines, units
+}
+
+//paragraphFoldUnits 段落をルビの表示方法に合わせて折り返しの単位に分ける
+//ルビは親文字と読みを、読みだけや親文字だけを表示する場合もそれぞれを一つの単位にして途中で折り返さない
+//傍点は上の行に表示できないので、表示方法によらず親文字の後に括弧で囲んだ傍点を付けて一つの単位にする
+func paragraphFoldUnits(p paragraph, style rubyStyle) []foldUnit {
+	units := []foldUnit{}
+	addUnit := func(str string) {
+		if str != "" {
+			units = append(units, newFoldUnit(str))
+		}
+	}
+	for _, s := range p.spans {
+		switch {
+		case s.kind == rubySpan && style.mode == inlineRuby:
+			addUnit(s.text + style.start + s.reading + style.end)
+		case s.kind == rubySpan && style.mode == readingOnlyRuby:
+			addUnit(s.reading)
+		case s.kind == rubySpan:
+			addUnit(s.text)
+		case s.kind == emphasisSpan:
+			addUnit(s.text + style.start + emphasisReading(s.text) + style.end)
+		case s.kind == imageSpan:
+			units = append(units, splitFoldUnits(imageText(s))...)
+		default:
+			units = append(units, splitFoldUnits(s.text)...)
+		}
+	}
+	return units
+}
+
+//emphasisReading 傍点の親文字の数だけ傍点を並べる
+func emphasisReading(text string) string {
+	return strings.Repeat("﹅", utf8.RuneCountInString(text))
+}
+
+//imageText 挿絵を表示する文字列
+func imageText(s span) string {
+	return "［挿絵：" + s.src + "］"
+}
+
+//rubyPosition 折り返した行の中でのルビの位置
+type rubyPosition struct {
+	column  int    //親文字の開始位置(表示幅)
+	width   int    //親文字の表示幅
+	reading string //読み。傍点では親文字の数だけ傍点を並べる
+}
+
+//twoRowLines 段落を幅wで折り返し、ルビか傍点がある行ではその上に読みの行を加える
+//ルビの親文字は途中で折り返さず、収まらなければ次の行に送る
+func twoRowLines(p paragraph, w int) []string {
+	lines := []string{}
+	var base strings.Builder
+	column := 0
+	rubies := []rubyPosition{}
+	newLine := func() {
+		if len(rubies) > 0 {
+			lines = append(lines, rubyRow(rubies, w))
+		}
+		lines = append(lines, base.String())
+		base.Reset()
+		column = 0
+		rubies = []rubyPosition{}
+	}
+	addRunes := func(text string) {
+		for _, r := range text {
+			if column+runewidth.RuneWidth(r) > w {
+				newLine()
+			}
+			base.WriteRune(r)
+			column += runewidth.RuneWidth(r)
+		}
+	}
+	for _, s := range p.spans {
+		switch s.kind {
+		case rubySpan, emphasisSpan:
+			reading := s.reading
+			if s.kind == emphasisSpan {
+				reading = emphasisReading(s.text)
+			}
+			baseWidth := runewidth.StringWidth(s.text)
+			if column+baseWidth > w && baseWidth <= w {
+				newLine()
+			}
+			if baseWidth > w {
+				//一行に収まらない親文字はルビを付けずに折り返す
+				addRunes(s.text)
+				continue
+			}
+			rubies = append(rubies, rubyPosition{column, baseWidth, reading})
+			base.WriteString(s.text)
+			column += baseWidth
+		case imageSpan:
+			addRunes(imageText(s))
+		default:
+			addRunes(s.text)
+		}
+	}
+	newLine()
+	return lines
+}
+
+//rubyRow 読みを親文字の中央に揃えて並べた行を作る。行の端では内側に寄せ、読みが重なる場合は後ろにずらし、幅wを超える分は切り捨てる
+func rubyRow(rubies []rubyPosition, w int) string {
+	var row strings.Builder
+	column := 0
+	for _, r := range rubies {
+		readingWidth := runewidth.StringWidth(r.reading)
+		start := r.column + (r.width-readingWidth)/2
+		if start+readingWidth > w {
+			start = w - readingWidth
+		}
+		if start < column {
+			start = column
+		}
+		row.WriteString(strings.Repeat(" ", start-column))
+		column = start
+		for _, c := range r.reading {
+			if column+runewidth.RuneWidth(c) > w {
+				return row.String()
+			}
+			row.WriteRune(c)
+			column += runewidth.RuneWidth(c)
+		}
+	}
+	return row.String()
+}
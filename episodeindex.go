@@ -0,0 +1,68 @@
+package main
+
+//目次による各話の移動
+//各話は目次の番号(storyInformation.number)で表し、目次の何番目かとは区別する
+//削除された話があると番号が飛ぶので、前後の話は番号を足し引きせずに目次の並びから探す
+
+//episodeIndex 小説の目次。各話を目次の順に並べたもの
+type episodeIndex []storyInformation
+
+//position 番号numberの話が目次の何番目か。見つからなければ-1を返す
+func (index episodeIndex) position(number int) int {
+	for i, s := range index {
+		if s.number == number {
+			return i
+		}
+	}
+	return -1
+}
+
+//find 番号numberの話を返す
+func (index episodeIndex) find(number int) (storyInformation, bool) {
+	if i := index.position(number); i >= 0 {
+		return index[i], true
+	}
+	return storyInformation{}, false
+}
+
+//next 番号numberの次の話を返す。最後の話なら見つからない
+//numberの話が目次に無い(削除された)場合は、番号がnumberより後の最初の話を返す
+func (index episodeIndex) next(number int) (storyInformation, bool) {
+	if i := index.position(number); i >= 0 {
+		if i+1 < len(index) {
+			return index[i+1], true
+		}
+		return storyInformation{}, false
+	}
+	for _, s := range index {
+		if s.number > number {
+			return s, true
+		}
+	}
+	return storyInformation{}, false
+}
+
+//previous 番号numberの前の話を返す。最初の話なら見つからない
+//numberの話が目次に無い(削除された)場合は、番号がnumberより前の最後の話を返す
+func (index episodeIndex) previous(number int) (storyInformation, bool) {
+	if i := index.position(number); i >= 0 {
+		if i > 0 {
+			return index[i-1], true
+		}
+		return storyInformation{}, false
+	}
+	for i := len(index) - 1; i >= 0; i-- {
+		if index[i].number < number {
+			return index[i], true
+		}
+	}
+	return storyInformation{}, false
+}
+
+//chapterChange 話fromから話toへ移動すると章が変わる場合は、toの章名を返す。変わらなければ空文字列を返す
+func chapterChange(from, to storyInformation) string {
+	if to.chapterTitle == from.chapterTitle {
+		return ""
+	}
+	return to.chapterTitle
+}
@@ -0,0 +1,88 @@
+package main
+
+import "testing"
+
+//testIndex 3話と7話が削除された目次。5話から章が変わる
+var testIndex = episodeIndex{
+	{1, "一話", "第一章", ""},
+	{2, "二話", "第一章", ""},
+	{4, "四話", "第一章", ""},
+	{5, "五話", "第二章", ""},
+	{6, "六話", "第二章", ""},
+	{8, "八話", "第二章", ""},
+}
+
+func TestEpisodeIndexFind(t *testing.T) {
+	tests := []struct {
+		name     string
+		number   int
+		want     int //見つかった話の番号
+		wantOK   bool
+		position int
+	}{
+		{"最初の話", 1, 1, true, 0},
+		{"番号が飛んだ後の話", 4, 4, true, 2},
+		{"最後の話", 8, 8, true, 5},
+		{"削除された話", 3, 0, false, -1},
+		{"目次より後の番号", 9, 0, false, -1},
+		{"0話", 0, 0, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := testIndex.find(tt.number)
+			if ok != tt.wantOK || got.number != tt.want {
+				t.Errorf("find(%d) = %d, %v, want %d, %v", tt.number, got.number, ok, tt.want, tt.wantOK)
+			}
+			if p := testIndex.position(tt.number); p != tt.position {
+				t.Errorf("position(%d) = %d, want %d", tt.number, p, tt.position)
+			}
+		})
+	}
+}
+
+func TestEpisodeIndexNextPrevious(t *testing.T) {
+	tests := []struct {
+		name            string
+		number          int
+		next            int    //次の話の番号。無ければ0
+		previous        int    //前の話の番号。無ければ0
+		nextChapter     string //次の話へ移動すると変わる章名
+		previousChapter string //前の話へ移動すると変わる章名
+	}{
+		{"最初の話には前が無い", 1, 2, 0, "", ""},
+		{"最後の話には次が無い", 8, 0, 6, "", ""},
+		{"削除された話を飛ばして次へ", 2, 4, 1, "", ""},
+		{"章の最後の話から次の章へ、削除された話を飛ばして前へ", 4, 5, 2, "第二章", ""},
+		{"章の最初の話から前の章へ", 5, 6, 4, "", "第一章"},
+		{"削除された話を読んでいる", 3, 4, 2, "", ""},
+		{"削除された最後より前の話を読んでいる", 7, 8, 6, "", ""},
+		{"目次より後の番号", 9, 0, 8, "", ""},
+		{"目次より前の番号", 0, 1, 0, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, ok := testIndex.find(tt.number)
+			next, hasNext := testIndex.next(tt.number)
+			if hasNext != (tt.next != 0) || next.number != tt.next {
+				t.Errorf("next(%d) = %d, %v, want %d", tt.number, next.number, hasNext, tt.next)
+			}
+			previous, hasPrevious := testIndex.previous(tt.number)
+			if hasPrevious != (tt.previous != 0) || previous.number != tt.previous {
+				t.Errorf("previous(%d) = %d, %v, want %d", tt.number, previous.number, hasPrevious, tt.previous)
+			}
+			if !ok {
+				return //章の変化は目次にある話についてだけ確かめる
+			}
+			if hasNext {
+				if got := chapterChange(current, next); got != tt.nextChapter {
+					t.Errorf("chapterChange(%d, %d) = %q, want %q", tt.number, next.number, got, tt.nextChapter)
+				}
+			}
+			if hasPrevious {
+				if got := chapterChange(current, previous); got != tt.previousChapter {
+					t.Errorf("chapterChange(%d, %d) = %q, want %q", tt.number, previous.number, got, tt.previousChapter)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+//小説の取得に失敗した理由
+//通信、サーバーの応答、解析、小説の削除を区別し、エラー画面で理由と対処を表示する
+
+import (
+	"errors"
+	"strconv"
+)
+
+//fetchErrorKind 取得に失敗した理由の種類
+type fetchErrorKind int
+
+const (
+	//networkError 通信に失敗した
+	networkError fetchErrorKind = iota
+	//httpStatusError サーバーがエラーの状態を返した
+	httpStatusError
+	//parseError ページやAPIの応答を解析できなかった
+	parseError
+	//notFoundError 小説や話が見つからない。削除されたか非公開になった
+	notFoundError
+)
+
+//fetchError 取得に失敗した理由
+type fetchError struct {
+	kind    fetchErrorKind
+	message string //画面に表示する説明
+	url     string //取得しようとしたURL。無ければ空
+	status  int    //サーバーが返した状態。httpStatusError以外では0
+	err     error  //元になったエラー。無ければnil
+}
+
+//Error エラーの説明を返す
+func (e *fetchError) Error() string {
+	str := e.message
+	if e.status != 0 {
+		str += "(" + strconv.Itoa(e.status) + ")"
+	}
+	if e.err != nil {
+		str += "：" + e.err.Error()
+	}
+	return str
+}
+
+//Unwrap 元になったエラーを返す
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+//newFetchError 元のエラーに理由を付けて作成
+func newFetchError(kind fetchErrorKind, pageURL string, err error) *fetchError {
+	messages := map[fetchErrorKind]string{
+		networkError:    "通信に失敗しました",
+		httpStatusError: "サーバーがエラーを返しました",
+		parseError:      "取得した内容を解析できませんでした",
+		notFoundError:   "見つかりませんでした",
+	}
+	return &fetchError{kind, messages[kind], pageURL, 0, err}
+}
+
+//fetchErrorKindOf エラーの理由の種類を返す。fetchErrorでなければokがfalseになる
+func fetchErrorKindOf(err error) (kind fetchErrorKind, ok bool) {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.kind, true
+	}
+	return 0, false
+}
+
+//fetchErrorHint エラーの理由に応じた対処の説明
+func fetchErrorHint(err error) string {
+	kind, ok := fetchErrorKindOf(err)
+	if !ok {
+		return ""
+	}
+	switch kind {
+	case networkError:
+		return "通信環境を確認してから再試行してください。"
+	case httpStatusError:
+		return "サーバーが混雑しているか、メンテナンス中の可能性があります。時間をおいて再試行してください。"
+	case parseError:
+		return "サイトの構成が変わった可能性があります。"
+	case notFoundError:
+		return "小説が削除されたか、非公開になった可能性があります。"
+	}
+	return ""
+}
+
+//fetchErrorURL 取得しようとしたURLを返す。分からなければ空文字列を返す
+func fetchErrorURL(err error) string {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.url
+	}
+	return ""
+}
@@ -0,0 +1,235 @@
+package main
+
+//ハーメルン(syosetu.org)を解析
+//ハーメルンにはなろうAPIのようなAPIが無いので、小説情報も目次のページから取得する
+//小説のIDは数字のみなので、なろうのNコードと重複しない
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const hamelnURL = "https://syosetu.org"      //ハーメルンのURL
+const hamelnTimeLayout = "2006年01月02日 15:04" //ハーメルンの目次における日付のフォーマット(曜日を除いたもの)
+const hamelnSearchPerPage = 10               //ハーメルンの検索結果の1ページあたりの件数
+
+//hamelnSite ハーメルン。novelSourceを実装する
+type hamelnSite struct{}
+
+//hameln ハーメルン
+var hameln = &hamelnSite{}
+
+var (
+	hamelnWeekdayRegexp = regexp.MustCompile(`\(.\)`)               //日付に含まれる曜日
+	hamelnCountRegexp   = regexp.MustCompile(`([0-9,]+)件`)          //検索結果の総数
+	hamelnNovelIDRegexp = regexp.MustCompile(`/novel/([0-9]+)/?$`)  //小説のURLに含まれるID
+	hamelnStoryRegexp   = regexp.MustCompile(`^\./([0-9]+)\.html$`) //目次に含まれる各話へのリンク
+)
+
+//sourceID ライブラリに保存するサイトの識別子
+func (site *hamelnSite) sourceID() string {
+	return "hameln"
+}
+
+//siteName 画面に表示するサイト名
+func (site *hamelnSite) siteName() string {
+	return "ハーメルン"
+}
+
+//searchOrders ハーメルンの検索では並び順を指定できない
+func (site *hamelnSite) searchOrders() []searchOrder {
+	return nil
+}
+
+//genreFilters ハーメルンの検索ではジャンルを指定できない
+func (site *hamelnSite) genreFilters() []genreFilter {
+	return nil
+}
+
+//search キーワードで小説を検索する。ハーメルンで指定できないなろうAPIの検索条件は無視する
+func (site *hamelnSite) search(ctx context.Context, filter url.Values, st, lim int) (allcount int, results []searchResult, err error) {
+	values := url.Values{}
+	values.Set("mode", "search")
+	values.Set("word", filter.Get("word"))
+	if notword := filter.Get("notword"); notword != "" {
+		values.Set("notword", notword)
+	}
+
+	//st件目が含まれるページからlim件集まるまで順に取得する
+	results = []searchResult{}
+	page := (st-1)/hamelnSearchPerPage + 1
+	skip := (st - 1) % hamelnSearchPerPage
+	for len(results) < lim {
+		values.Set("page", strconv.Itoa(page))
+		doc, err := getDocument(ctx, hamelnURL+"/search/?"+values.Encode())
+		if err != nil {
+			return 0, nil, err
+		}
+		if m := hamelnCountRegexp.FindStringSubmatch(doc.Find("#maind").Text()); m != nil {
+			allcount, _ = strconv.Atoi(strings.ReplaceAll(m[1], ",", ""))
+		}
+		pageResults := hamelnSearchResults(doc)
+		if skip < len(pageResults) {
+			results = append(results, pageResults[skip:]...)
+		}
+		if len(pageResults) < hamelnSearchPerPage {
+			break //最後のページ
+		}
+		skip = 0
+		page++
+	}
+	if len(results) > lim {
+		results = results[:lim]
+	}
+	return allcount, results, nil
+}
+
+//hamelnSearchResults 検索結果のページから各小説を取り出す
+func hamelnSearchResults(doc *goquery.Document) []searchResult {
+	results := []searchResult{}
+	doc.Find("div.section3").Each(func(_ int, s *goquery.Selection) {
+		link := s.Find("div.blo_title_base a").First()
+		href, _ := link.Attr("href")
+		m := hamelnNovelIDRegexp.FindStringSubmatch(href)
+		if m == nil {
+			return //小説ではない項目
+		}
+		author := strings.TrimSpace(s.Find("div.blo_title_sak").Text())
+		if i := strings.Index(author, "："); i >= 0 {
+			author = strings.TrimSpace(author[i+len("："):]) //「作者：」を除く
+		}
+		results = append(results, searchResult{
+			m[1],
+			strings.TrimSpace(link.Text()),
+			author,
+			strings.TrimSpace(s.Find("div.blo_inword").Text()),
+		})
+	})
+	return results
+}
+
+//fetchInformations 目次のページから小説情報を一つずつ取得する
+func (site *hamelnSite) fetchInformations(ctx context.Context, ncodes []string) (map[string]*novelinformation, error) {
+	infos := map[string]*novelinformation{}
+	for _, ncode := range ncodes {
+		doc, err := getDocument(ctx, hamelnURL+"/novel/"+ncode+"/")
+		if err != nil {
+			return infos, err
+		}
+		title := strings.TrimSpace(doc.Find("span[itemprop='name']").First().Text())
+		if title == "" {
+			continue //削除されたか非公開の小説
+		}
+		info := newNovelinformation()
+		info.site = site
+		info.ncode = strings.ToLower(ncode)
+		info.title = title
+		info.author = strings.TrimSpace(doc.Find("span[itemprop='author']").First().Text())
+		info.synopsis, _ = doc.Find("meta[property='og:description']").Attr("content")
+		stories := hamelnIndex(doc)
+		info.allcount = len(stories)
+		info.isrensai = len(stories) > 0
+		if !info.isrensai {
+			info.allcount = 1 //短編は目次が無く、本文だけの一話の小説とする
+		}
+		for _, s := range stories {
+			//各話の掲載日時から初回掲載日と最終掲載日を求める
+			t, err := time.ParseInLocation(hamelnTimeLayout, s.updatedAt, time.Local)
+			if err != nil {
+				continue
+			}
+			if info.firstpostingdate.IsZero() || t.Before(info.firstpostingdate) {
+				info.firstpostingdate = t
+			}
+			if t.After(info.lastpostingdate) {
+				info.lastpostingdate = t
+			}
+		}
+		info.novelupdatedat = info.lastpostingdate
+		infos[info.ncode] = info
+	}
+	return infos, nil
+}
+
+//fetchIndex 目次のページから各話の一覧を取得
+func (site *hamelnSite) fetchIndex(ctx context.Context, ncode string) ([]storyInformation, error) {
+	doc, err := getDocument(ctx, hamelnURL+"/novel/"+ncode+"/")
+	if err != nil {
+		return nil, err
+	}
+	return hamelnIndex(doc), nil
+}
+
+//hamelnIndex 目次の表から各話の一覧を取り出す。章の行の後にその章の各話の行が並んでいる
+func hamelnIndex(doc *goquery.Document) []storyInformation {
+	chapterTitle := ""
+	stories := []storyInformation{}
+	doc.Find("div.ss table tr").Each(func(_ int, tr *goquery.Selection) {
+		if chapter := tr.Find("td[colspan] strong"); chapter.Length() > 0 {
+			//章の行
+			chapterTitle = strings.TrimSpace(chapter.Text())
+			return
+		}
+		link := tr.Find("a").First()
+		href, _ := link.Attr("href")
+		m := hamelnStoryRegexp.FindStringSubmatch(href)
+		if m == nil {
+			return
+		}
+		number, _ := strconv.Atoi(m[1])
+		stories = append(stories, storyInformation{
+			number,
+			strings.TrimSpace(link.Text()),
+			chapterTitle,
+			hamelnUpdatedAt(tr.Find("nobr")),
+		})
+	})
+	return stories
+}
+
+//hamelnUpdatedAt 目次の各話の項目から掲載日時を取得。改稿されていれば改稿日時を返す
+func hamelnUpdatedAt(s *goquery.Selection) string {
+	date := s.Contents().First().Text()
+	if kaikou, ok := s.Find("span").Attr("title"); ok {
+		//改稿日時は「2017年03月05日(日) 18:00改稿」の形式
+		date = strings.TrimSuffix(kaikou, "改稿")
+	}
+	return strings.TrimSpace(hamelnWeekdayRegexp.ReplaceAllString(date, ""))
+}
+
+//fetchStory 本文のページから前書き、本文、後書きを取得
+func (site *hamelnSite) fetchStory(ctx context.Context, ncode string, storyNum int) (*episode, error) {
+	doc, err := getDocument(ctx, hamelnURL+"/novel/"+ncode+"/"+strconv.Itoa(storyNum)+".html")
+	if err != nil {
+		return nil, err
+	}
+	return hamelnEpisode(doc)
+}
+
+//fetchShortStory 短編の前書き、本文、後書きを取得。短編は目次のページに本文がある
+func (site *hamelnSite) fetchShortStory(ctx context.Context, ncode string) (*episode, error) {
+	doc, err := getDocument(ctx, hamelnURL+"/novel/"+ncode+"/")
+	if err != nil {
+		return nil, err
+	}
+	return hamelnEpisode(doc)
+}
+
+//hamelnEpisode 本文のページから前書き、本文、後書きを取り出す
+func hamelnEpisode(doc *goquery.Document) (*episode, error) {
+	story := &episode{
+		parseParagraphs(doc.Find("div#maegaki")),
+		parseParagraphs(doc.Find("div#honbun")),
+		parseParagraphs(doc.Find("div#atogaki")),
+	}
+	if story.honbun == nil {
+		return nil, errStoryNotFound
+	}
+	return story, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+//サイトへの通信
+//全ての取得はこのクライアントを通し、タイムアウト、User-Agent、再試行、ホストごとの取得間隔を揃える
+//なろうのクローラーの規約に合わせ、同じホストへは間隔を空けて取得し、混雑している時は待ち時間を延ばして再試行する
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const httpClientFileName = "http.json" //通信の設定を保存するファイル名
+
+//httpSettings 通信の設定
+type httpSettings struct {
+	userAgent  string        //User-Agentヘッダー
+	timeout    time.Duration //一回の取得のタイムアウト
+	interval   time.Duration //同じホストへ取得を始める最小の間隔
+	retries    int           //5xxと429、通信の失敗で再試行する回数
+	backoff    time.Duration //最初の再試行までの待ち時間。再試行のたびに倍にする
+	maxBackoff time.Duration //再試行までの待ち時間の上限
+}
+
+//defaultHTTPSettings 初期の通信の設定
+var defaultHTTPSettings = httpSettings{
+	"narougayomitai-go (+https://github.com/seki-syo/narougayomitai-go)",
+	30 * time.Second,
+	time.Second,
+	3,
+	time.Second,
+	30 * time.Second,
+}
+
+//httpSettingsjson http.jsonの形式。書かれていない項目は初期の設定のままにする
+type httpSettingsjson struct {
+	UserAgent  string `json:"useragent"`  //User-Agentヘッダー
+	Timeout    int    `json:"timeout"`    //一回の取得のタイムアウト(秒)
+	Interval   int    `json:"interval"`   //同じホストへ取得を始める最小の間隔(ミリ秒)
+	Retries    int    `json:"retries"`    //再試行する回数
+	Backoff    int    `json:"backoff"`    //最初の再試行までの待ち時間(ミリ秒)
+	MaxBackoff int    `json:"maxbackoff"` //再試行までの待ち時間の上限(ミリ秒)
+}
+
+//sharedHTTPClient 全ての取得に使うクライアント。起動時に設定を読み込んで作り直す
+var sharedHTTPClient = newHTTPClient(defaultHTTPSettings)
+
+//httpClient 設定に従って取得するクライアント
+type httpClient struct {
+	settings httpSettings
+	client   *http.Client
+	mutex    sync.Mutex
+	next     map[string]time.Time //ホストごとの次に取得を始められる時刻
+}
+
+//loadHTTPSettings 通信の設定を読み込む
+//ファイルが存在しない場合や読み込みに失敗した場合は初期の設定を返す
+func loadHTTPSettings() (httpSettings, error) {
+	f, err := os.Open(filepath.Join(dataDir(), httpClientFileName))
+	if os.IsNotExist(err) {
+		return defaultHTTPSettings, nil
+	}
+	if err != nil {
+		return defaultHTTPSettings, err
+	}
+	defer f.Close()
+
+	d := defaultHTTPSettings
+	intermediatesettings := httpSettingsjson{
+		d.userAgent,
+		int(d.timeout / time.Second),
+		int(d.interval / time.Millisecond),
+		d.retries,
+		int(d.backoff / time.Millisecond),
+		int(d.maxBackoff / time.Millisecond),
+	}
+	if err := json.NewDecoder(f).Decode(&intermediatesettings); err != nil {
+		return defaultHTTPSettings, err
+	}
+	s := intermediatesettings
+	if s.Retries < 0 {
+		s.Retries = 0
+	}
+	return httpSettings{
+		s.UserAgent,
+		time.Duration(s.Timeout) * time.Second,
+		time.Duration(s.Interval) * time.Millisecond,
+		s.Retries,
+		time.Duration(s.Backoff) * time.Millisecond,
+		time.Duration(s.MaxBackoff) * time.Millisecond,
+	}, nil
+}
+
+//newHTTPClient 設定からクライアントを作る
+func newHTTPClient(settings httpSettings) *httpClient {
+	return &httpClient{
+		settings: settings,
+		client:   &http.Client{Timeout: settings.timeout},
+		next:     map[string]time.Time{},
+	}
+}
+
+//get ページを取得する。5xxと429の応答と通信の失敗は待ち時間を延ばしながら再試行する
+//再試行しても失敗した場合は最後の応答かエラーを返す。応答は呼び出し側で閉じる
+//ctxがキャンセルされると、取得中の通信と間隔や再試行を待っている取得を中断する
+func (c *httpClient) get(ctx context.Context, pageURL string, cookies ...*http.Cookie) (*http.Response, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx, u.Host); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", c.settings.userAgent)
+		for _, ck := range cookies {
+			req.AddCookie(ck)
+		}
+		resp, err := c.client.Do(req)
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err() //中断された場合は再試行しない
+		}
+		if !retryable(resp, err) || attempt >= c.settings.retries {
+			return resp, err
+		}
+		delay := c.backoffDelay(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body) //接続を使い回すために読み切る
+			resp.Body.Close()
+		}
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//retryable 再試行すれば成功する可能性があるならtrue
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+//wait 同じホストへの前回の取得から間隔が空くまで待つ
+//待っている間に次の取得の時刻を予約するので、並行して取得してもホストごとに間隔を空けて一つずつ始まる
+//待っている間に中断された場合は、後から予約した取得が無ければ予約を取り消して、次の取得を待たせない
+func (c *httpClient) wait(ctx context.Context, host string) error {
+	c.mutex.Lock()
+	now := time.Now()
+	previous := c.next[host]
+	start := previous
+	if start.Before(now) {
+		start = now
+	}
+	reserved := start.Add(c.settings.interval)
+	c.next[host] = reserved
+	c.mutex.Unlock()
+	if err := c.sleep(ctx, start.Sub(now)); err != nil {
+		c.mutex.Lock()
+		if c.next[host].Equal(reserved) {
+			c.next[host] = previous
+		}
+		c.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+//backoffDelay attempt回目の再試行までの待ち時間
+//最初の待ち時間を再試行のたびに倍にして上限で抑え、同時に再試行が集中しないように半分から全体の間でばらつかせる
+//サーバーがRetry-Afterで秒数か日時を指定した場合は、それより短くしない。ただし上限は超えない
+func (c *httpClient) backoffDelay(attempt int, resp *http.Response) time.Duration {
+	delay := c.settings.backoff << uint(attempt)
+	if delay > c.settings.maxBackoff || delay <= 0 {
+		delay = c.settings.maxBackoff
+	}
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	if after, ok := retryAfter(resp); ok && after > delay {
+		delay = after
+	}
+	if delay > c.settings.maxBackoff {
+		delay = c.settings.maxBackoff
+	}
+	return delay
+}
+
+//retryAfter サーバーがRetry-Afterで指定した待ち時間。秒数とHTTPの日時のどちらの形式も読む。指定が無いか読めなければokはfalse
+func retryAfter(resp *http.Response) (d time.Duration, ok bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+//sleep dだけ待つ。ctxがキャンセルされた場合はエラーを返す
+func (c *httpClient) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testHTTPSettings テストで待たずに済むように間隔と待ち時間を短くした設定
+func testHTTPSettings() httpSettings {
+	return httpSettings{
+		"narougayomitai-test",
+		5 * time.Second,
+		0,
+		2,
+		time.Millisecond,
+		5 * time.Millisecond,
+	}
+}
+
+//statusServer 順にstatusesの状態を返すサーバー。最後の状態はその後も返し続ける。requestsは受け取った取得の数
+func statusServer(t *testing.T, statuses ...int) (server *httptest.Server, requests func() int) {
+	t.Helper()
+	var mutex sync.Mutex
+	count := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		status := statuses[len(statuses)-1]
+		if count < len(statuses) {
+			status = statuses[count]
+		}
+		count++
+		mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return count
+	}
+}
+
+func TestHTTPClientRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int //最後に返す状態
+		requests int //サーバーが受け取る取得の数
+	}{
+		{"成功したら再試行しない", []int{200}, 200, 1},
+		{"5xxは再試行する", []int{503, 502, 200}, 200, 3},
+		{"429は再試行する", []int{429, 200}, 200, 2},
+		{"再試行しても失敗したら最後の応答を返す", []int{500}, 500, 3},
+		{"404は再試行しない", []int{404, 200}, 404, 1},
+		{"403は再試行しない", []int{403, 200}, 403, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := statusServer(t, tt.statuses...)
+			resp, err := newHTTPClient(testHTTPSettings()).get(context.Background(), server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if n := requests(); n != tt.requests {
+				t.Errorf("取得の数 = %d, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestHTTPClientUserAgent(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	settings := testHTTPSettings()
+	resp, err := newHTTPClient(settings).get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ua := <-got; ua != settings.userAgent {
+		t.Errorf("User-Agent = %q, want %q", ua, settings.userAgent)
+	}
+}
+
+func TestHTTPClientBackoffDelay(t *testing.T) {
+	settings := testHTTPSettings()
+	settings.backoff = 100 * time.Millisecond
+	settings.maxBackoff = time.Second
+	c := newHTTPClient(settings)
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"最初の再試行", 0, "", 50 * time.Millisecond, 100 * time.Millisecond},
+		{"再試行のたびに倍にする", 2, "", 200 * time.Millisecond, 400 * time.Millisecond},
+		{"上限で抑える", 10, "", 500 * time.Millisecond, time.Second},
+		{"倍にして溢れても上限で抑える", 100, "", 500 * time.Millisecond, time.Second},
+		{"Retry-Afterより短くしない", 0, "1", time.Second, time.Second},
+		{"Retry-Afterも上限で抑える", 0, "3600", time.Second, time.Second},
+		{"読めないRetry-Afterは使わない", 0, "soon", 50 * time.Millisecond, 100 * time.Millisecond},
+		{"日時のRetry-Afterも読む", 0, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), time.Second, time.Second},
+		{"過ぎた日時のRetry-Afterは使わない", 0, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 50 * time.Millisecond, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			for i := 0; i < 20; i++ { //ばらつかせるので何度か確かめる
+				if d := c.backoffDelay(tt.attempt, resp); d < tt.min || d > tt.max {
+					t.Fatalf("backoffDelay(%d) = %v, want %v～%v", tt.attempt, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPClientInterval(t *testing.T) {
+	var mutex sync.Mutex
+	times := []time.Time{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	other, _ := statusServer(t, 200)
+
+	settings := testHTTPSettings()
+	settings.interval = 100 * time.Millisecond
+	c := newHTTPClient(settings)
+	get := func(pageURL string) {
+		resp, err := c.get(context.Background(), pageURL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	//並行して取得しても同じホストへは間隔を空けて一つずつ始める
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(server.URL)
+		}()
+	}
+	wg.Wait()
+	if len(times) != 3 {
+		t.Fatalf("取得の数 = %d, want 3", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		//サーバーが受け取る時刻は多少ずれるので少し余裕を見る
+		if gap := times[i].Sub(times[i-1]); gap < settings.interval-20*time.Millisecond {
+			t.Errorf("%d回目と%d回目の間隔 = %v, want %v以上", i, i+1, gap, settings.interval)
+		}
+	}
+
+	//別のホストへは待たずに取得する
+	start := time.Now()
+	get(other.URL)
+	if elapsed := time.Since(start); elapsed >= settings.interval {
+		t.Errorf("別のホストの取得に%vかかった", elapsed)
+	}
+}
+
+func TestHTTPClientCancel(t *testing.T) {
+	//応答を返さずに、取得が中断されるまで待つサーバー
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer blocking.Close()
+	unavailable, _ := statusServer(t, 503)
+
+	tests := []struct {
+		name   string
+		url    string
+		modify func(s *httpSettings)
+		first  bool //先に一度取得して、次の取得を間隔が空くまで待たせる
+	}{
+		{"取得中", blocking.URL, func(s *httpSettings) {}, false},
+		{"再試行を待っている間", unavailable.URL, func(s *httpSettings) {
+			s.backoff = time.Minute
+			s.maxBackoff = time.Minute
+		}, false},
+		{"同じホストへの間隔を待っている間", unavailable.URL, func(s *httpSettings) {
+			s.interval = time.Minute
+			s.retries = 0
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := testHTTPSettings()
+			tt.modify(&settings)
+			c := newHTTPClient(settings)
+			if tt.first {
+				resp, err := c.get(context.Background(), tt.url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			start := time.Now()
+			resp, err := c.get(ctx, tt.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("err = %v, want %v", err, context.Canceled)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("中断してから戻るまでに%vかかった", elapsed)
+			}
+		})
+	}
+}
+
+func TestHTTPClientCancelReleasesInterval(t *testing.T) {
+	server, _ := statusServer(t, 200)
+	settings := testHTTPSettings()
+	settings.interval = 200 * time.Millisecond
+	c := newHTTPClient(settings)
+	get := func(ctx context.Context) error {
+		resp, err := c.get(ctx, server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	//間隔を待っている間に中断した取得の分は、次の取得を待たせない
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := get(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+	start := time.Now()
+	if err := get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*settings.interval {
+		t.Errorf("中断した取得の後に%v待った", elapsed)
+	}
+}
@@ -7,38 +7,36 @@ import (
 var (
 	lockerChan chan bool //入力禁止をやり取りするチャンネル
 	inputLock  bool      //trueの時は処理を受け付けない
-	//キー押下時実行変数
-	pushKeyArrowUp    func()
-	pushKeyArrowDown  func()
-	pushKeyArrowLeft  func()
-	pushKeyArrowRight func()
-	pushKeyEsc        func()
-	pushKeyEnterSpace func()
-	pushKeyHome       func()
-	pushKeyEnd        func()
+	//キーの割り当て
+	keys keymap
+	//続けて押すキーの途中で、押されたキーの名前
+	pendingKeys []string
+	//操作ごとに実行する関数。現在の画面が設定する
+	keyHandlers map[keyAction]func()
+	//文字入力中に全てのキーイベントを受け取る関数。nilなら文字入力中ではない
+	textInputFunc func(ev termbox.Event)
+	//画面の大きさが変わった時に実行する関数
+	resizeFunc func()
+	//他のgoroutineから入力を処理するgoroutineで実行させる関数
+	uiTasks = make(chan func(), 16)
 )
 
 //inputLoop 入力イベントをループで取得(ich:termboxのキーイベントを受け取る。 endch:trueを送信すると終了する)
 func inputLoop() {
 	inputLock = false
 	lockerChan = make(chan bool, 1)
-	pushKeyEsc = func() {}
-	pushKeyArrowUp = func() {}
-	pushKeyArrowDown = func() {}
-	pushKeyArrowLeft = func() {}
-	pushKeyArrowRight = func() {}
-	pushKeyEsc = func() {}
-	pushKeyEnterSpace = func() {}
-	pushKeyHome = func() {}
-	pushKeyEnd = func() {}
-	ich := make(chan termbox.Key, 1)
+	keys, _ = loadKeymap() //読み込みに失敗した場合は初期の割り当てを使う
+	pendingKeys = nil
+	keyHandlers = map[keyAction]func(){}
+	resizeFunc = func() {}
+	ich := make(chan termbox.Event, 1)
 	termbox.SetInputMode(termbox.InputAlt)
 
 	go func() {
 		for {
 			switch ev := termbox.PollEvent(); ev.Type {
-			case termbox.EventKey:
-				ich <- ev.Key
+			case termbox.EventKey, termbox.EventResize:
+				ich <- ev
 			default:
 			}
 		}
@@ -48,47 +46,85 @@ func inputLoop() {
 		select {
 		//case inputLock = <-lockerChan: //ロックフラグを通信して変更
 
-		case k := <-ich:
+		case f := <-uiTasks:
+			//画面と小説の一覧は入力を処理するgoroutineだけが触る
+			f()
+		case ev := <-ich:
+			if ev.Type == termbox.EventResize {
+				//画面の大きさが変わったとき
+				width, height = ev.Width, ev.Height
+				if textInputFunc == nil {
+					//文字入力中は入力中の文字列を残すために描画し直さない
+					resizeFunc()
+				}
+				continue
+			}
 			//キーイベントを受け取ったとき
-			switch k {
-			case termbox.KeyArrowUp:
-				pushKeyArrowUp()
-			case termbox.KeyArrowDown:
-				pushKeyArrowDown()
-			case termbox.KeyArrowLeft:
-				pushKeyArrowLeft()
-			case termbox.KeyArrowRight:
-				pushKeyArrowRight()
-			case termbox.KeyEnter, termbox.KeySpace:
-				pushKeyEnterSpace() //選択項目を実行
-			case termbox.KeyEsc, termbox.KeyBackspace:
-				pushKeyEsc()
-			case termbox.KeyHome, termbox.KeyF1:
-				pushKeyHome()
-			case termbox.KeyEnd, termbox.KeyF2:
-				pushKeyEnd()
-			case termbox.KeyF12:
-				appquiet <- true //強制終了
-			default:
+			name := keyName(ev)
+			if textInputFunc != nil {
+				//文字入力中は全てのキーを入力欄に渡す。強制終了は文字キー以外に割り当てた場合のみ受け付ける
+				if action, found, _ := keys.match([]string{name}); found && action == actionQuit && ev.Ch == 0 {
+					appquiet <- true
+					continue
+				}
+				textInputFunc(ev)
+				continue
+			}
+			if name == "" {
+				pendingKeys = nil
+				continue
+			}
+			pendingKeys = append(pendingKeys, name)
+			action, found, waiting := keys.match(pendingKeys)
+			if !found && !waiting && len(pendingKeys) > 1 {
+				//続けて押すキーの途中で割り当ての無いキーが押されたら、そのキーだけで探し直す
+				pendingKeys = []string{name}
+				action, found, waiting = keys.match(pendingKeys)
+			}
+			if waiting {
+				continue //続くキーを待つ
+			}
+			pendingKeys = nil
+			if found {
+				runKeyAction(action)
 			}
 		default:
 		}
 	}
 }
 
+//SetTextInputFunction 文字入力中に全てのキーイベントを受け取る関数を設定する。nilを設定すると文字入力を終了する
+func SetTextInputFunction(f func(ev termbox.Event)) {
+	textInputFunc = f
+}
+
+//SetResizeFunction 画面の大きさが変わった時に実行する関数を設定する
+func SetResizeFunction(f func()) {
+	resizeFunc = f
+}
+
+//runOnUI 入力を処理するgoroutineでfを実行させる。画面や小説の一覧を他のgoroutineから変更する時に使う
+func runOnUI(f func()) {
+	uiTasks <- f
+}
+
 //SetInputLock 入力を禁止する
 func SetInputLock(flag bool) {
 	lockerChan <- flag
 }
 
-//SetInputFunction キー押下時の実行関数を設定する
-func SetInputFunction(arrowUp, arrowDown, arrowLeft, arrowRight, esc, enterSpace, home, end func()) {
-	pushKeyArrowUp = arrowUp
-	pushKeyArrowDown = arrowDown
-	pushKeyArrowLeft = arrowLeft
-	pushKeyArrowRight = arrowRight
-	pushKeyEsc = esc
-	pushKeyEnterSpace = enterSpace
-	pushKeyHome = home
-	pushKeyEnd = end
+//SetKeyActions 操作ごとに実行する関数を設定する。設定されていない操作のキーは何もしない
+func SetKeyActions(handlers map[keyAction]func()) {
+	keyHandlers = handlers
+}
+
+//runKeyAction 操作に設定された関数を実行する
+func runKeyAction(action keyAction) {
+	if action == actionQuit {
+		appquiet <- true //強制終了
+		return
+	}
+	if f := keyHandlers[action]; f != nil {
+		f()
+	}
 }
@@ -0,0 +1,211 @@
+package main
+
+//キー割り当て
+//キーに名前の付いた操作を割り当て、各画面は操作ごとに実行する関数を設定する
+//文字キーと、ggのように続けて押すキーも割り当てられる。割り当てはデータディレクトリのkeymap.jsonで変更できる
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+)
+
+const keymapFileName = "keymap.json" //キー割り当てを保存するファイル名
+
+//keyAction キーに割り当てる操作の名前
+type keyAction string
+
+const (
+	actionUp              keyAction = "up"              //上へ移動
+	actionDown            keyAction = "down"            //下へ移動
+	actionLeft            keyAction = "left"            //左(前のページ)
+	actionRight           keyAction = "right"           //右(次のページ)
+	actionCancel          keyAction = "cancel"          //戻る
+	actionSelect          keyAction = "select"          //決定
+	actionTop             keyAction = "top"             //先頭へ移動
+	actionBottom          keyAction = "bottom"          //末尾へ移動
+	actionHalfPageDown    keyAction = "halfpagedown"    //半画面下へ移動
+	actionHalfPageUp      keyAction = "halfpageup"      //半画面上へ移動
+	actionPageDown        keyAction = "pagedown"        //一画面下へ移動
+	actionPageUp          keyAction = "pageup"          //一画面上へ移動
+	actionJumpPercent     keyAction = "jumppercent"     //入力した割合の位置へ移動
+	actionSearchForward   keyAction = "searchforward"   //後ろへ向かって本文内を検索
+	actionSearchBackward  keyAction = "searchbackward"  //前へ向かって本文内を検索
+	actionSearchNext      keyAction = "searchnext"      //検索した向きに次の一致へ移動
+	actionSearchPrevious  keyAction = "searchprevious"  //検索した向きと逆に次の一致へ移動
+	actionNextEpisode     keyAction = "nextepisode"     //次の話へ
+	actionPreviousEpisode keyAction = "previousepisode" //前の話へ
+	actionQuit            keyAction = "quit"            //強制終了
+)
+
+//defaultKeyBindings 初期のキー割り当て。矢印キーなどに加えてvimとlessに近いキーを割り当てる
+//特殊キーは<Up>のように、Ctrlとの組み合わせは<C-d>のように書く
+var defaultKeyBindings = map[keyAction][]string{
+	actionUp:              {"<Up>", "k"},
+	actionDown:            {"<Down>", "j"},
+	actionLeft:            {"<Left>", "h"},
+	actionRight:           {"<Right>", "l"},
+	actionCancel:          {"<Esc>", "<BS>", "q"},
+	actionSelect:          {"<Enter>", "<Space>"},
+	actionTop:             {"<Home>", "<F1>", "gg"},
+	actionBottom:          {"<End>", "<F2>", "G"},
+	actionHalfPageDown:    {"<C-d>"},
+	actionHalfPageUp:      {"<C-u>"},
+	actionPageDown:        {"<PageDown>", "f", "<C-f>"},
+	actionPageUp:          {"<PageUp>", "b", "<C-b>"},
+	actionJumpPercent:     {"%"},
+	actionSearchForward:   {"/"},
+	actionSearchBackward:  {"?"},
+	actionSearchNext:      {"<C-n>", "<F3>"},
+	actionSearchPrevious:  {"<C-p>", "<F4>"},
+	actionNextEpisode:     {"n"},
+	actionPreviousEpisode: {"p"},
+	actionQuit:            {"<F12>"},
+}
+
+//specialKeyNames 特殊キーの名前
+var specialKeyNames = map[termbox.Key]string{
+	termbox.KeyArrowUp:    "Up",
+	termbox.KeyArrowDown:  "Down",
+	termbox.KeyArrowLeft:  "Left",
+	termbox.KeyArrowRight: "Right",
+	termbox.KeyEnter:      "Enter",
+	termbox.KeySpace:      "Space",
+	termbox.KeyEsc:        "Esc",
+	termbox.KeyBackspace:  "BS",
+	termbox.KeyBackspace2: "BS",
+	termbox.KeyTab:        "Tab",
+	termbox.KeyInsert:     "Insert",
+	termbox.KeyDelete:     "Delete",
+	termbox.KeyHome:       "Home",
+	termbox.KeyEnd:        "End",
+	termbox.KeyPgup:       "PageUp",
+	termbox.KeyPgdn:       "PageDown",
+	termbox.KeyF1:         "F1",
+	termbox.KeyF2:         "F2",
+	termbox.KeyF3:         "F3",
+	termbox.KeyF4:         "F4",
+	termbox.KeyF5:         "F5",
+	termbox.KeyF6:         "F6",
+	termbox.KeyF7:         "F7",
+	termbox.KeyF8:         "F8",
+	termbox.KeyF9:         "F9",
+	termbox.KeyF10:        "F10",
+	termbox.KeyF11:        "F11",
+	termbox.KeyF12:        "F12",
+}
+
+//keymap キーの並びと操作の対応。キーの並びはキーの名前を空白で繋げたもの
+type keymap map[string]keyAction
+
+//keymapjson keymap.jsonの形式。操作の名前ごとにキーの並びの一覧を書く
+type keymapjson map[string][]string
+
+//loadKeymap キー割り当てを読み込む。ファイルに書かれていない操作は初期の割り当てのままにする
+//ファイルが存在しない場合や読み込みに失敗した場合は初期の割り当てを返す
+func loadKeymap() (keymap, error) {
+	bindings := map[keyAction][]string{}
+	for action, keys := range defaultKeyBindings {
+		bindings[action] = keys
+	}
+	f, err := os.Open(filepath.Join(dataDir(), keymapFileName))
+	if os.IsNotExist(err) {
+		return newKeymap(bindings), nil
+	}
+	if err != nil {
+		return newKeymap(bindings), err
+	}
+	defer f.Close()
+
+	var intermediatekeymap keymapjson
+	if err := json.NewDecoder(f).Decode(&intermediatekeymap); err != nil {
+		return newKeymap(bindings), err
+	}
+	for name, keys := range intermediatekeymap {
+		if _, ok := defaultKeyBindings[keyAction(name)]; !ok {
+			continue //知らない操作は読み飛ばす
+		}
+		bindings[keyAction(name)] = keys
+	}
+	return newKeymap(bindings), nil
+}
+
+//newKeymap 操作ごとのキーの並びからkeymapを作る
+func newKeymap(bindings map[keyAction][]string) keymap {
+	km := keymap{}
+	for action, keys := range bindings {
+		for _, k := range keys {
+			if seq := parseKeySequence(k); len(seq) > 0 {
+				km[strings.Join(seq, " ")] = action
+			}
+		}
+	}
+	return km
+}
+
+//parseKeySequence 設定に書かれたキーの並びをキーの名前に分ける。<>で囲まれていない文字は一文字ずつのキーとする
+func parseKeySequence(str string) []string {
+	keys := []string{}
+	for i := 0; i < len(str); {
+		if str[i] == '<' {
+			if end := strings.IndexByte(str[i:], '>'); end > 1 {
+				keys = append(keys, normalizeKeyName(str[i+1:i+end]))
+				i += end + 1
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if r == ' ' {
+			keys = append(keys, "<Space>")
+		} else {
+			keys = append(keys, string(r))
+		}
+		i += size
+	}
+	return keys
+}
+
+//normalizeKeyName <>の中に書かれたキーの名前を揃える。大文字小文字を区別せず、Ctrlとの組み合わせは<C-x>にする
+func normalizeKeyName(name string) string {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "c-") && len(lower) == 3 {
+		return "<C-" + lower[2:] + ">"
+	}
+	for _, n := range specialKeyNames {
+		if strings.ToLower(n) == lower {
+			return "<" + n + ">"
+		}
+	}
+	return "<" + name + ">"
+}
+
+//keyName キーイベントをキーの名前にする。名前の付けられないキーは空文字列を返す
+func keyName(ev termbox.Event) string {
+	if ev.Ch != 0 {
+		return string(ev.Ch)
+	}
+	if name, ok := specialKeyNames[ev.Key]; ok {
+		return "<" + name + ">"
+	}
+	if ev.Key >= termbox.KeyCtrlA && ev.Key <= termbox.KeyCtrlZ {
+		return "<C-" + string(rune('a'+ev.Key-termbox.KeyCtrlA)) + ">"
+	}
+	return ""
+}
+
+//match 押されたキーの並びに割り当てられた操作を探す
+//waitingは並びが他の割り当ての途中であることを表し、その場合は続くキーを待つ
+func (km keymap) match(keys []string) (action keyAction, found, waiting bool) {
+	seq := strings.Join(keys, " ")
+	for k := range km {
+		if strings.HasPrefix(k, seq+" ") {
+			return "", false, true
+		}
+	}
+	action, found = km[seq]
+	return action, found, false
+}
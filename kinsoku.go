@@ -0,0 +1,155 @@
+package main
+
+//禁則処理
+//行頭と行末に置けない文字を避けて折り返し、句読点は行末にぶら下げる
+//……や――のような続けて使う記号と、ルビの親文字と読みは途中で折り返さない
+//ルビは表示する文字列から推測せず、段落の要素から作った折り返しの単位で親文字と読みをまとめる
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+//lineStartProhibited 行頭に置けない文字
+const lineStartProhibited = "、。，．,.・：；:;？！?!ー～〜‐゛゜ヽヾゝゞ々〻" +
+	"）］｝」』】〕〉》〙〗〟’”)]}" +
+	"ぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇺㇻㇼㇽㇾㇿ"
+
+//lineEndProhibited 行末に置けない文字
+const lineEndProhibited = "（［｛「『【〔〈《〘〖〝‘“([{"
+
+//hangingPunctuation 行末にぶら下げる句読点
+const hangingPunctuation = "、。，．,."
+
+//inseparableRunes 続けて使う場合に途中で折り返さない記号
+const inseparableRunes = "…‥―─"
+
+//foldUnit 折り返しの単位。途中で折り返さない文字の並び
+type foldUnit struct {
+	str   string
+	width int
+}
+
+//newFoldUnit 文字列を一つの単位にする
+func newFoldUnit(str string) foldUnit {
+	return foldUnit{str, runewidth.StringWidth(str)}
+}
+
+//first 先頭の文字
+func (u foldUnit) first() rune {
+	for _, r := range u.str {
+		return r
+	}
+	return 0
+}
+
+//last 末尾の文字
+func (u foldUnit) last() rune {
+	runes := []rune(u.str)
+	if len(runes) == 0 {
+		return 0
+	}
+	return runes[len(runes)-1]
+}
+
+//kinsokuFold 文字列を禁則処理をしながら幅wで折り返す
+func kinsokuFold(str string, w int) []string {
+	return kinsokuFoldUnits(splitFoldUnits(str), w)
+}
+
+//kinsokuFoldUnits 折り返しの単位を禁則処理をしながら幅wで折り返す。ぶら下げた句読点を除いて幅wを超えない
+func kinsokuFoldUnits(units []foldUnit, w int) []string {
+	lines := []string{}
+	line := []foldUnit{}
+	lineWidth := 0
+	flush := func() {
+		lines = append(lines, joinUnits(line))
+		line = []foldUnit{}
+		lineWidth = 0
+	}
+	queue := units
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if u.width > w {
+			//一行に収まらない単位は一文字ずつに分ける
+			for _, r := range u.str {
+				if lineWidth+runewidth.RuneWidth(r) > w && len(line) > 0 {
+					flush()
+				}
+				line = append(line, foldUnit{string(r), runewidth.RuneWidth(r)})
+				lineWidth += runewidth.RuneWidth(r)
+			}
+			continue
+		}
+		if lineWidth+u.width <= w {
+			line = append(line, u)
+			lineWidth += u.width
+			continue
+		}
+		nextIsProhibited := len(queue) > 0 && strings.ContainsRune(lineStartProhibited, queue[0].first())
+		if len(line) > 0 && strings.ContainsRune(hangingPunctuation, u.first()) && u.str == string(u.first()) && !nextIsProhibited {
+			//句読点は行末にぶら下げる。続く文字も行頭に置けない場合は追い出す
+			line = append(line, u)
+			flush()
+			continue
+		}
+		//行末と次の行頭が禁則に触れない位置まで戻って折り返す
+		k := len(line)
+		for ; k > 0; k-- {
+			next := u
+			if k < len(line) {
+				next = line[k]
+			}
+			if canBreakBetween(line[k-1], next) {
+				break
+			}
+		}
+		if k == 0 {
+			k = len(line) //禁則を守れない場合はそのまま折り返す
+		}
+		rest := append([]foldUnit{}, line[k:]...)
+		line = line[:k]
+		flush()
+		//次の行へ送った単位は、幅に収まるかを確かめながら置き直す
+		queue = append(append(rest, u), queue...)
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		flush()
+	}
+	return lines
+}
+
+//canBreakBetween beforeとafterの間で折り返せるならtrue
+func canBreakBetween(before, after foldUnit) bool {
+	return !strings.ContainsRune(lineEndProhibited, before.last()) && !strings.ContainsRune(lineStartProhibited, after.first())
+}
+
+//splitFoldUnits 文字列を一文字ずつの折り返しの単位に分ける。続けて使う記号はまとめる
+func splitFoldUnits(str string) []foldUnit {
+	units := []foldUnit{}
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		unit := str[i : i+size]
+		if strings.ContainsRune(inseparableRunes, r) {
+			//同じ記号が続く間は一つの単位にする
+			for strings.HasPrefix(str[i+len(unit):], str[i:i+size]) {
+				unit += str[i : i+size]
+			}
+		}
+		units = append(units, foldUnit{unit, runewidth.StringWidth(unit)})
+		i += len(unit)
+	}
+	return units
+}
+
+//joinUnits 単位を繋げた文字列を返す
+func joinUnits(units []foldUnit) string {
+	var b strings.Builder
+	for _, u := range units {
+		b.WriteString(u.str)
+	}
+	return b.String()
+}
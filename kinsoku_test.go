@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+)
+
+//checkFolded 折り返した行を繋げると元に戻り、ぶら下げた句読点を除いて幅wを超えないことを確かめる
+func checkFolded(t *testing.T, str string, w int, got []string) {
+	t.Helper()
+	if joined := strings.Join(got, ""); joined != str {
+		t.Errorf("繋げると元に戻らない: %q", joined)
+	}
+	for _, l := range got {
+		trimmed := strings.TrimRight(l, hangingPunctuation)
+		if runewidth.StringWidth(trimmed) > w {
+			t.Errorf("%qの幅が%dを超えている", l, w)
+		}
+	}
+}
+
+func TestKinsokuFold(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		w    int
+		want []string
+	}{
+		{"行頭禁則の閉じ括弧は前の文字と送る", "あいうえお」かき", 10, []string{"あいうえ", "お」かき"}},
+		{"行頭禁則の小書き文字は前の文字と送る", "あいうえおっかき", 10, []string{"あいうえ", "おっかき"}},
+		{"行末禁則の開き括弧は次の行へ送る", "あいうえ「おかき", 10, []string{"あいうえ", "「おかき"}},
+		{"開き括弧が続いても次の行へ送る", "あいう「『おかき", 10, []string{"あいう", "「『おかき"}},
+		{"句点をぶら下げる", "あいうえお。かきくけこ", 10, []string{"あいうえお。", "かきくけこ"}},
+		{"読点をぶら下げる", "あいうえお、かきくけこ", 10, []string{"あいうえお、", "かきくけこ"}},
+		{"句点の後も行頭禁則ならぶら下げずに送る", "あいうえお。」かき", 10, []string{"あいうえ", "お。」かき"}},
+		{"三点リーダーの並びを分けない", "あいう……えお", 7, []string{"あいう", "……えお"}},
+		{"ダッシュの並びを分けない", "あいう――えお", 7, []string{"あいう", "――えお"}},
+		{"一行に収まらない記号の並びは一文字ずつに分ける", "あ…………………………", 8, []string{"あ………………", "…………"}},
+		{"禁則を守れない場合もそのまま折り返す", "「「「「「「", 8, []string{"「「「「", "「「"}},
+		{"送った文字も幅を超えない", "a「『（（字《じ》です", 10, []string{"a", "「『（（字", "《じ》です"}},
+		{"幅に収まる行はそのまま", "あいう", 10, []string{"あいう"}},
+		{"空の行", "", 10, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := kinsokuFold(tt.str, tt.w)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kinsokuFold(%q, %d) = %q, want %q", tt.str, tt.w, got, tt.want)
+			}
+			checkFolded(t, tt.str, tt.w, got)
+		})
+	}
+}
+
+func TestKinsokuFoldRuby(t *testing.T) {
+	inline := rubyStyle{inlineRuby, "《", "》"}
+	tests := []struct {
+		name  string
+		spans []span
+		style rubyStyle
+		w     int
+		want  []string
+	}{
+		{"カタカナの親文字を分けない", []span{
+			{textSpan, "あいうえおかきくけこさしす", "", ""},
+			{rubySpan, "ドラゴン", "りゅう", ""},
+			{textSpan, "です。", "", ""},
+		}, inline, 30, []string{"あいうえおかきくけこさしす", "ドラゴン《りゅう》です。"}},
+		{"漢字の親文字を分けない", []span{
+			{textSpan, "あいうえ", "", ""},
+			{rubySpan, "小説家", "しょうせつか", ""},
+			{textSpan, "になろう", "", ""},
+		}, inline, 22, []string{"あいうえ", "小説家《しょうせつか》", "になろう"}},
+		{"親文字の前の開き括弧も一緒に送る", []span{
+			{textSpan, "あいう「", "", ""},
+			{rubySpan, "魔法", "まほう", ""},
+			{textSpan, "」だ", "", ""},
+		}, inline, 18, []string{"あいう", "「魔法《まほう》」", "だ"}},
+		{"送った単位が幅を超える場合は折り返し直す", []span{
+			{textSpan, "a「『（（", "", ""},
+			{rubySpan, "字", "じ", ""},
+			{textSpan, "です", "", ""},
+		}, inline, 10, []string{"a", "「『（（", "字《じ》で", "す"}},
+		{"読みだけを表示する場合も読みを分けない", []span{
+			{textSpan, "あいうえ", "", ""},
+			{rubySpan, "竜", "ドラゴン", ""},
+			{textSpan, "だ", "", ""},
+		}, rubyStyle{readingOnlyRuby, "", ""}, 10, []string{"あいうえ", "ドラゴンだ"}},
+		{"傍点は親文字の後に括弧で囲んで分けない", []span{
+			{textSpan, "あいうえお", "", ""},
+			{emphasisSpan, "ここ", "", ""},
+			{textSpan, "だ", "", ""},
+		}, inline, 14, []string{"あいうえお", "ここ《﹅﹅》だ"}},
+		{"親文字だけを表示する場合も傍点は表示する", []span{
+			{emphasisSpan, "ここ", "", ""},
+			{textSpan, "だ", "", ""},
+		}, rubyStyle{baseOnlyRuby, "《", "》"}, 20, []string{"ここ《﹅﹅》だ"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units := paragraphFoldUnits(paragraph{0, tt.spans}, tt.style)
+			got := kinsokuFoldUnits(units, tt.w)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kinsokuFoldUnits = %q, want %q", got, tt.want)
+			}
+			checkFolded(t, joinUnits(units), tt.w, got)
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+//入手した小説の一覧と、しおりや保存した検索条件を管理する
+//一覧はホームディレクトリ以下のデータディレクトリにjsonで保存される
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dataDirName = ".narougayomitai"  //データを保存するディレクトリ名
+const libraryFileName = "library.json" //入手した小説の一覧を保存するファイル名
+
+var novelLibrary *library //入手した小説の一覧
+
+//library 入手した小説の一覧
+//一覧としおりは入力を処理するgoroutineだけが読み書きする。他のgoroutineからはrunOnUIを通して変更する
+//設定は読み込んだ後は変わらないので、どのgoroutineから読んでもよい
+type library struct {
+	novels     []*novelinformation //入手した小説の情報
+	bookmarks  map[string]bookmark //Nコードごとのしおり
+	searches   []savedSearch       //保存した検索条件
+	settings   appSettings         //設定
+	mu         sync.Mutex          //ファイルへの保存を一つずつ行う
+	loadFailed bool                //ファイルはあるが読み込めなかったならtrue。空の一覧で元のファイルを上書きしないように保存しない
+}
+
+//errLibraryNotLoaded 読み込めなかった一覧のファイルを上書きしないように保存しなかった
+var errLibraryNotLoaded = errors.New("小説の一覧を読み込めなかったため、元のファイルを残すように保存していません")
+
+//bookmark しおり。読んでいる話数と表示位置を記録する
+type bookmark struct {
+	episode   int //読んでいる話数
+	line      int //MultiLineViewerで表示している行
+	paragraph int //表示している段落(折り返す前の行)。画面の幅が変わっても同じ段落から読める。記録していなければ-1
+}
+
+//appSettings 設定。ファイルを直接編集して変更する
+type appSettings struct {
+	r18      bool         //R18サイトを使用するならtrue
+	notes    notesDisplay //前書きと後書きの表示方法
+	ruby     rubyStyle    //ルビの表示方法
+	vertical bool         //本文を縦書きで表示するならtrue
+}
+
+//rubyStyle ルビの表示方法
+type rubyStyle struct {
+	mode  rubyDisplay //表示方法
+	start string      //文中に表示する時に読みの前に付ける括弧
+	end   string      //文中に表示する時に読みの後に付ける括弧
+}
+
+//rubyDisplay ルビの表示方法の種類
+type rubyDisplay int
+
+const (
+	//inlineRuby 親文字の後に括弧で囲んだ読みを表示する
+	inlineRuby rubyDisplay = iota
+	//baseOnlyRuby 親文字のみ表示する
+	baseOnlyRuby
+	//readingOnlyRuby 親文字の代わりに読みを表示する
+	readingOnlyRuby
+	//twoRowRuby 親文字の上の行に読みを表示する
+	twoRowRuby
+)
+
+//rubyDisplayKeys 設定ファイルに書くルビの表示方法の名前
+var rubyDisplayKeys = map[rubyDisplay]string{
+	inlineRuby:      "inline",
+	baseOnlyRuby:    "base",
+	readingOnlyRuby: "reading",
+	twoRowRuby:      "tworow",
+}
+
+//parseRubyStyle 設定ファイルに書かれた内容からルビの表示方法を返す。不明な名前なら文中に《》で表示する
+func parseRubyStyle(key, start, end string) rubyStyle {
+	style := rubyStyle{inlineRuby, start, end}
+	for d, k := range rubyDisplayKeys {
+		if k == key {
+			style.mode = d
+		}
+	}
+	if start == "" && end == "" {
+		style.start = "《"
+		style.end = "》"
+	}
+	return style
+}
+
+//notesDisplay 前書きと後書きの表示方法
+type notesDisplay int
+
+const (
+	//showNotes 前書きと後書きを表示する
+	showNotes notesDisplay = iota
+	//hideNotes 前書きと後書きを表示しない
+	hideNotes
+	//foldNotes 前書きと後書きを畳んでおき、Enterキーで開く
+	foldNotes
+)
+
+//notesDisplayKeys 設定ファイルに書く表示方法の名前
+var notesDisplayKeys = map[notesDisplay]string{
+	showNotes: "show",
+	hideNotes: "hide",
+	foldNotes: "fold",
+}
+
+//parseNotesDisplay 設定ファイルに書かれた名前から表示方法を返す。不明な名前なら表示する
+func parseNotesDisplay(key string) notesDisplay {
+	for d, k := range notesDisplayKeys {
+		if k == key {
+			return d
+		}
+	}
+	return showNotes
+}
+
+//savedSearch 名前を付けて保存した検索条件
+type savedSearch struct {
+	site         novelSource //検索したサイト
+	name         string      //保存した名前
+	searchString string      //検索条件について記述した文字列
+	filter       url.Values  //サイトに渡す検索条件
+}
+
+//ファイルに保存するための中間構造体
+type libraryjson struct {
+	Novels    []libraryNoveljson      `json:"novels"`
+	Bookmarks map[string]bookmarkjson `json:"bookmarks"`
+	Searches  []savedSearchjson       `json:"searches"`
+	Settings  settingsjson            `json:"settings"`
+}
+
+//設定をファイルに保存するための中間構造体
+type settingsjson struct {
+	R18       bool   `json:"r18"`       //R18サイトを使用する(初期値は使用しない)
+	Notes     string `json:"notes"`     //前書きと後書きの表示方法。show、hide、foldのいずれか(初期値はshow)
+	Ruby      string `json:"ruby"`      //ルビの表示方法。inline、base、reading、tworowのいずれか(初期値はinline)
+	RubyStart string `json:"rubystart"` //inlineで読みの前に付ける括弧(初期値は《)
+	RubyEnd   string `json:"rubyend"`   //inlineで読みの後に付ける括弧(初期値は》)
+	Vertical  bool   `json:"vertical"`  //本文を縦書きで表示する(初期値は横書き)
+}
+
+//保存した検索条件をファイルに保存するための中間構造体
+type savedSearchjson struct {
+	Name         string `json:"name"`
+	SearchString string `json:"searchstring"`
+	Query        string `json:"query"`  //url.Valuesをエンコードしたもの
+	Source       string `json:"source"` //検索したサイトの識別子
+}
+
+//しおりをファイルに保存するための中間構造体
+type bookmarkjson struct {
+	Episode   int  `json:"episode"`
+	Line      int  `json:"line"`
+	Paragraph *int `json:"paragraph,omitempty"` //段落を記録する前に保存したしおりには無い
+}
+
+//小説情報をファイルに保存するための中間構造体
+type libraryNoveljson struct {
+	Ncode            string    `json:"ncode"`
+	Title            string    `json:"title"`
+	Author           string    `json:"author"`
+	Allcount         int       `json:"allcount"`
+	Firstpostingdate time.Time `json:"firstpostingdate"`
+	Lastpostingdate  time.Time `json:"lastpostingdate"`
+	Novelupdatedat   time.Time `json:"novelupdatedat"`
+	Isrensai         bool      `json:"isrensai"`
+	Isend            bool      `json:"isend"`
+	Isr15            bool      `json:"isr15"`
+	Isbl             bool      `json:"isbl"`
+	Isgl             bool      `json:"isgl"`
+	Iszankoku        bool      `json:"iszankoku"`
+	Istensei         bool      `json:"istensei"`
+	Istenni          bool      `json:"istenni"`
+	Synopsis         string    `json:"synopsis"`
+	Keyword          string    `json:"keyword"`
+	Biggenre         int       `json:"biggenre"`
+	Smallgenre       int       `json:"smallgenre"`
+	Nocgenre         int       `json:"nocgenre"`
+	Source           string    `json:"source"` //掲載サイトの識別子
+	Currentcount     int       `json:"currentcount"`
+	Islock           bool      `json:"islock"`
+	Hasupdate        bool      `json:"hasupdate"`
+}
+
+//dataDir データを保存するディレクトリのパスを返す
+func dataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "." //ホームディレクトリが取得できない場合は実行ディレクトリに保存
+	}
+	return filepath.Join(home, dataDirName)
+}
+
+//loadLibrary 保存されている小説の一覧を読み込む。ファイルが存在しない場合は空の一覧を返す
+//ファイルを読み込めなかった場合は、保存しない空の一覧とエラーを返す
+func loadLibrary() (*library, error) {
+	lib := &library{novels: []*novelinformation{}, bookmarks: map[string]bookmark{}, settings: appSettings{ruby: parseRubyStyle("", "", "")}}
+	f, err := os.Open(filepath.Join(dataDir(), libraryFileName))
+	if os.IsNotExist(err) {
+		return lib, nil //まだ一冊も入手していない
+	}
+	if err != nil {
+		lib.loadFailed = true
+		return lib, err
+	}
+	defer f.Close()
+
+	var intermediatelib libraryjson
+	err = json.NewDecoder(f).Decode(&intermediatelib)
+	if err != nil {
+		lib.loadFailed = true
+		return lib, err
+	}
+	for _, n := range intermediatelib.Novels {
+		lib.novels = append(lib.novels, n.novelinformation())
+	}
+	for ncode, b := range intermediatelib.Bookmarks {
+		paragraph := -1
+		if b.Paragraph != nil {
+			paragraph = *b.Paragraph
+		}
+		lib.bookmarks[ncode] = bookmark{b.Episode, b.Line, paragraph}
+	}
+	for _, s := range intermediatelib.Searches {
+		filter, err := url.ParseQuery(s.Query)
+		if err != nil {
+			continue //壊れた検索条件は読み飛ばす
+		}
+		lib.searches = append(lib.searches, savedSearch{sourceFromID(s.Source), s.Name, s.SearchString, filter})
+	}
+	settings := intermediatelib.Settings
+	lib.settings = appSettings{
+		settings.R18,
+		parseNotesDisplay(settings.Notes),
+		parseRubyStyle(settings.Ruby, settings.RubyStart, settings.RubyEnd),
+		settings.Vertical,
+	}
+	return lib, nil
+}
+
+//save 小説の一覧をファイルに保存する。読み込めなかった一覧は保存しない
+func (lib *library) save() error {
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if lib.loadFailed {
+		return errLibraryNotLoaded
+	}
+	err := os.MkdirAll(dataDir(), 0755)
+	if err != nil {
+		return err
+	}
+	settings := settingsjson{
+		lib.settings.r18,
+		notesDisplayKeys[lib.settings.notes],
+		rubyDisplayKeys[lib.settings.ruby.mode],
+		lib.settings.ruby.start,
+		lib.settings.ruby.end,
+		lib.settings.vertical,
+	}
+	intermediatelib := libraryjson{[]libraryNoveljson{}, map[string]bookmarkjson{}, []savedSearchjson{}, settings}
+	for _, info := range lib.novels {
+		intermediatelib.Novels = append(intermediatelib.Novels, newLibraryNoveljson(info))
+	}
+	for ncode, b := range lib.bookmarks {
+		b := b
+		intermediatelib.Bookmarks[ncode] = bookmarkjson{b.episode, b.line, &b.paragraph}
+	}
+	for _, s := range lib.searches {
+		intermediatelib.Searches = append(intermediatelib.Searches, savedSearchjson{s.name, s.searchString, s.filter.Encode(), s.site.sourceID()})
+	}
+
+	//書き込み途中で終了しても一覧が壊れないように一時ファイルに書いてから置き換える
+	path := filepath.Join(dataDir(), libraryFileName)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(intermediatelib)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//find Nコードで小説を検索する。見つからなければnilを返す
+func (lib *library) find(ncode string) *novelinformation {
+	for _, info := range lib.novels {
+		if info.ncode == ncode {
+			return info
+		}
+	}
+	return nil
+}
+
+//add 小説を一覧に追加する。既に入手済みの場合は情報を置き換える
+func (lib *library) add(info *novelinformation) {
+	for i, n := range lib.novels {
+		if n.ncode == info.ncode {
+			lib.novels[i] = info
+			return
+		}
+	}
+	lib.novels = append(lib.novels, info)
+}
+
+//remove Nコードで指定した小説を一覧から削除する
+func (lib *library) remove(ncode string) {
+	for i, n := range lib.novels {
+		if n.ncode == ncode {
+			lib.novels = append(lib.novels[:i], lib.novels[i+1:]...)
+			return
+		}
+	}
+}
+
+//sourceEnabled 設定で使用できるサイトならtrue。R18サイトは設定で有効にした場合のみ使用できる
+func (lib *library) sourceEnabled(site novelSource) bool {
+	return site != narouR18 || lib.settings.r18
+}
+
+//updateTargets 更新を確認する小説のIDをサイトごとにまとめる。更新ロック中の小説と、設定で使用できないサイトの小説は含めない
+func (lib *library) updateTargets() map[novelSource][]string {
+	ncodes := map[novelSource][]string{}
+	for _, info := range lib.novels {
+		if !info.islock && lib.sourceEnabled(info.site) {
+			ncodes[info.site] = append(ncodes[info.site], info.ncode)
+		}
+	}
+	return ncodes
+}
+
+//fetchLatestInformations サイトごとにまとめて最新の小説情報を問い合わせる。戻り値は小文字の小説IDをキーとする
+//小説の一覧には触らないので、入力を処理するgoroutineの外で呼べる
+func fetchLatestInformations(ctx context.Context, ncodes map[novelSource][]string) (map[string]*novelinformation, error) {
+	latests := map[string]*novelinformation{}
+	for site, sitencodes := range ncodes {
+		infos, err := site.fetchInformations(ctx, sitencodes)
+		if err != nil {
+			return nil, err
+		}
+		for ncode, info := range infos {
+			latests[ncode] = info
+		}
+	}
+	return latests, nil
+}
+
+//applyUpdates 最新の小説情報を反映して保存し、更新があった小説を返す。更新ロック中の小説は反映しない
+func (lib *library) applyUpdates(latests map[string]*novelinformation) ([]*novelinformation, error) {
+	updated := []*novelinformation{}
+	for _, info := range lib.novels {
+		latest, ok := latests[strings.ToLower(info.ncode)]
+		if info.islock || !ok {
+			continue
+		}
+		if info.isUpdated(latest) || info.hasupdate {
+			info.applyLatest(latest)
+			info.hasupdate = true //更新分をダウンロードするまでは更新ありとする
+			updated = append(updated, info)
+		}
+	}
+	return updated, lib.save()
+}
+
+//bookmark Nコードで指定した小説のしおりを返す。しおりが無ければokはfalse
+func (lib *library) bookmark(ncode string) (b bookmark, ok bool) {
+	b, ok = lib.bookmarks[ncode]
+	return
+}
+
+//setBookmark しおりを挟む。入手済みの小説なら現在読んでいる話数も更新する
+func (lib *library) setBookmark(ncode string, episode, line, paragraph int) {
+	lib.bookmarks[ncode] = bookmark{episode, line, paragraph}
+	if info := lib.find(ncode); info != nil {
+		info.currentcount = episode
+	}
+}
+
+//removeBookmark しおりを削除する
+func (lib *library) removeBookmark(ncode string) {
+	delete(lib.bookmarks, ncode)
+}
+
+//addSearch 検索条件を名前を付けて保存する。同じ名前があれば置き換える
+func (lib *library) addSearch(site novelSource, name, searchString string, filter url.Values) {
+	s := savedSearch{site, name, searchString, copyValues(filter)}
+	for i, saved := range lib.searches {
+		if saved.name == name {
+			lib.searches[i] = s
+			return
+		}
+	}
+	lib.searches = append(lib.searches, s)
+}
+
+//removeSearch 名前で指定した検索条件を削除する
+func (lib *library) removeSearch(name string) {
+	for i, saved := range lib.searches {
+		if saved.name == name {
+			lib.searches = append(lib.searches[:i], lib.searches[i+1:]...)
+			return
+		}
+	}
+}
+
+//libraryLinesArray 入手した小説の一覧をLines配列に変換
+func libraryLinesArray(novels []*novelinformation) []Lines {
+	linesArr := []Lines{}
+	for _, info := range novels {
+		state := "既読：" + strconv.Itoa(info.currentcount) + "/" + strconv.Itoa(info.allcount) + "話"
+		state += "　保存済み：" + strconv.Itoa(countEpisodes(info.ncode)) + "話"
+		if info.hasupdate {
+			state += "　更新あり"
+		}
+		if info.islock {
+			state += "　更新ロック中"
+		}
+		linesArr = append(linesArr, Lines{info.title, "作者    　：" + info.author, state, ""})
+	}
+	return linesArr
+}
+
+//newLibraryNoveljson 小説情報を保存用の中間構造体に変換する
+func newLibraryNoveljson(info *novelinformation) libraryNoveljson {
+	return libraryNoveljson{
+		Ncode:            info.ncode,
+		Title:            info.title,
+		Author:           info.author,
+		Allcount:         info.allcount,
+		Firstpostingdate: info.firstpostingdate,
+		Lastpostingdate:  info.lastpostingdate,
+		Novelupdatedat:   info.novelupdatedat,
+		Isrensai:         info.isrensai,
+		Isend:            info.isend,
+		Isr15:            info.isr15,
+		Isbl:             info.isbl,
+		Isgl:             info.isgl,
+		Iszankoku:        info.iszankoku,
+		Istensei:         info.istensei,
+		Istenni:          info.istenni,
+		Synopsis:         info.synopsis,
+		Keyword:          info.keyword,
+		Biggenre:         info.biggenre.id,
+		Smallgenre:       info.smallgenre.id,
+		Nocgenre:         info.nocgenre.id,
+		Source:           info.site.sourceID(),
+		Currentcount:     info.currentcount,
+		Islock:           info.islock,
+		Hasupdate:        info.hasupdate,
+	}
+}
+
+//novelinformation 保存用の中間構造体を小説情報に変換する
+func (n libraryNoveljson) novelinformation() *novelinformation {
+	bg, _ := biggenres.FindID(n.Biggenre)
+	sg, _ := smallgenres.FindID(n.Smallgenre)
+	ng, _ := nocgenres.FindID(n.Nocgenre)
+	return &novelinformation{
+		site:             sourceFromID(n.Source),
+		ncode:            n.Ncode,
+		title:            n.Title,
+		author:           n.Author,
+		allcount:         n.Allcount,
+		firstpostingdate: n.Firstpostingdate,
+		lastpostingdate:  n.Lastpostingdate,
+		novelupdatedat:   n.Novelupdatedat,
+		isrensai:         n.Isrensai,
+		isend:            n.Isend,
+		isr15:            n.Isr15,
+		isbl:             n.Isbl,
+		isgl:             n.Isgl,
+		iszankoku:        n.Iszankoku,
+		istensei:         n.Istensei,
+		istenni:          n.Istenni,
+		synopsis:         n.Synopsis,
+		keyword:          n.Keyword,
+		biggenre:         bg,
+		smallgenre:       sg,
+		nocgenre:         ng,
+		currentcount:     n.Currentcount,
+		islock:           n.Islock,
+		hasupdate:        n.Hasupdate,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//useTempDataDir データディレクトリをテスト用の一時ディレクトリに置き換えて、そのパスを返す
+func useTempDataDir(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home) //Windows
+	return filepath.Join(home, dataDirName)
+}
+
+func TestLoadLibraryMissing(t *testing.T) {
+	dir := useTempDataDir(t)
+	lib, err := loadLibrary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lib.novels) != 0 {
+		t.Errorf("len(novels) = %d, want 0", len(lib.novels))
+	}
+	if err := lib.save(); err != nil {
+		t.Fatalf("ファイルが無い場合は保存できる: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, libraryFileName)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadLibraryBroken(t *testing.T) {
+	dir := useTempDataDir(t)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, libraryFileName)
+	broken := []byte(`{"novels": [{"ncode": "n0001a"`)
+	if err := os.WriteFile(path, broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := loadLibrary()
+	if err == nil {
+		t.Fatal("壊れたファイルを読み込めた")
+	}
+	if err := lib.save(); err != errLibraryNotLoaded {
+		t.Errorf("save() = %v, want %v", err, errLibraryNotLoaded)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(broken) {
+		t.Errorf("読み込めなかったファイルが書き換えられた: %q", got)
+	}
+}
+
+func TestR18Disabled(t *testing.T) {
+	novelLibrary = &library{bookmarks: map[string]bookmark{}}
+	novelLibrary.add(&novelinformation{site: narouGeneral, ncode: "n0001a"})
+	novelLibrary.add(&novelinformation{site: narouR18, ncode: "n0002a"})
+
+	want := map[novelSource][]string{narouGeneral: {"n0001a"}}
+	if got := novelLibrary.updateTargets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("updateTargets() = %v, want %v", got, want)
+	}
+	for _, s := range searchableSources() {
+		if s == narouR18 {
+			t.Error("設定で無効なR18サイトを検索できる")
+		}
+	}
+	//設定で無効なら通信する前に断る
+	if _, err := narouR18.fetchIndex(context.Background(), "n0002a"); err != errR18Disabled {
+		t.Errorf("fetchIndex() = %v, want %v", err, errR18Disabled)
+	}
+	if _, _, err := narouR18.search(context.Background(), nil, 0, 1); err != errR18Disabled {
+		t.Errorf("search() = %v, want %v", err, errR18Disabled)
+	}
+
+	novelLibrary.settings.r18 = true
+	if got := novelLibrary.updateTargets(); len(got[narouR18]) != 1 {
+		t.Errorf("設定で有効なR18サイトの小説の更新を確認しない: %v", got)
+	}
+}
@@ -2,21 +2,40 @@ package main
 
 //MultiLine Viewer
 import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
 //MultiLineViewer 複数行の文字を画面に表示するための構造体。termboxによる文字送りも可能
 type MultiLineViewer struct {
+	lines       []string //設定された折り返す前の行(段落)
 	foldedArray []string
-	currentLine int    //現在表示中のLine
-	cancelFunc  func() //Escキーが押された時に実行されるキャンセル処理
+	columns     [][]string   //縦書きの各列
+	cellLengths [][]int      //縦書きの各列の各マスに表示する元の文字列のバイト数
+	lineIndexes []int        //折り返した各行(縦書きでは各列)がlinesの何番目の行か
+	lineOffsets []int        //折り返した各行(縦書きでは各列)がlinesの行の何バイト目から始まるか
+	vertical    bool         //縦書きならtrue。currentLineは右端に表示中の列になる
+	foldUnits   [][]foldUnit //linesの各行の折り返しの単位。nilの行は文字列を一文字ずつに分ける
+	currentLine int          //現在表示中のLine
+	cancelFunc  func()       //Escキーが押された時に実行されるキャンセル処理
 	height      int
 	width       int
-	leftFunc    func() //左キーを押したときの関数
-	rightFunc   func() //右キーを押したときの関数
+	leftFunc    func()         //左キーを押したときの関数
+	rightFunc   func()         //右キーを押したときの関数
+	enterFunc   func()         //Enterキーを押したときの関数
+	moveFunc    func(line int) //表示行が変わったときの関数
+	showStatus  bool           //最下行に現在位置を表示するならtrue
+	statusInfo  string         //現在位置の後に表示する情報
+	input       *TextInput     //最下行で移動する位置や検索する文字列を入力する入力欄
+	search      viewerSearch   //本文内の検索
 }
 
+const readingRunesPerMinute = 500 //残りの読書時間の目安に使う一分間に読む文字数
+
 //NewMultiLineViewer 作成
 func NewMultiLineViewer() *MultiLineViewer {
 	return &MultiLineViewer{}
@@ -24,108 +43,456 @@ func NewMultiLineViewer() *MultiLineViewer {
 
 //Init 初期化
 func (v *MultiLineViewer) Init() {
-	v.foldedArray = []string{}
+	v.clearLines()
+	v.vertical = false
+	v.foldUnits = nil
 	v.currentLine = 0 //最上部の行から描画
 	v.cancelFunc = func() {}
 	v.leftFunc = func() {}
 	v.rightFunc = func() {}
+	v.enterFunc = func() {}
+	v.moveFunc = func(int) {}
+	v.showStatus = false
+	v.statusInfo = ""
+	v.input = NewTextInput()
+	v.search = viewerSearch{}
 	v.width, v.height = termbox.Size()
 	//キー押下時の動作を設定
-	SetInputFunction(v.moveUp, v.moveDown, v.leftFunc, v.rightFunc, v.cancelFunc, func() {}, v.moveTop, v.moveBottom)
+	v.setInput()
+}
+
+//clearLines 設定された文字列を消す
+func (v *MultiLineViewer) clearLines() {
+	v.lines = []string{}
+	v.foldedArray = []string{}
+	v.columns = [][]string{}
+	v.cellLengths = [][]int{}
+	v.lineIndexes = []int{}
+	v.lineOffsets = []int{}
 }
 
 //Draw 描画
 func (v *MultiLineViewer) Draw() {
+	v.drawPage()
+	v.drawStatus()
+	drawScreen()
+}
+
+//drawPage 本文を描画バッファに加える。検索に一致した文字列は色を変える
+func (v *MultiLineViewer) drawPage() {
+	if v.vertical {
+		v.drawVertical()
+		return
+	}
 	//一行ずつ描画
 	var drawLineCon int
-	if v.height < len(v.foldedArray) {
-		drawLineCon = v.height
+	if v.pageHeight() < len(v.foldedArray) {
+		drawLineCon = v.pageHeight()
 	} else {
 		drawLineCon = len(v.foldedArray)
 	}
 	for di := 0; di < drawLineCon; di++ {
-		drawLineNoStatic(v.foldedArray[di+v.currentLine], 0, di, defaultFg, defaultBg)
+		row := v.foldedArray[di+v.currentLine]
+		drawLineNoStatic(row, 0, di, defaultFg, defaultBg)
+		for _, h := range v.rowHighlights(di + v.currentLine) {
+			drawLineNoStatic(row[h.start:h.end], runewidth.StringWidth(row[:h.start]), di, h.fg, h.bg)
+		}
 	}
 	//デバッグ用
 	//drawLineNoStatic("drawLineCon = "+strconv.Itoa(drawLineCon), 60, 5, termbox.ColorRed, defaultBg)
 	//drawLineNoStatic("currentLine = "+strconv.Itoa(v.currentLine), 60, 6, termbox.ColorRed, defaultBg)
 	//drawLineNoStatic("foldedArrayLen = "+strconv.Itoa(len(v.foldedArray)), 60, 7, termbox.ColorRed, defaultBg)
 	//drawLineNoStatic("height = "+strconv.Itoa(v.height), 60, v.height-1, termbox.ColorRed, defaultBg)
-	drawScreen()
+}
+
+//drawVertical 縦書きで右の列から描画
+func (v *MultiLineViewer) drawVertical() {
+	for i := 0; i < v.pageColumns() && v.currentLine+i < len(v.columns); i++ {
+		x := v.width - (i+1)*tategakiColumnWidth
+		highlights := v.rowHighlights(v.currentLine + i)
+		offset := 0
+		for y, cell := range v.columns[v.currentLine+i] {
+			fg, bg := defaultFg, defaultBg
+			end := offset + v.cellLengths[v.currentLine+i][y]
+			for _, h := range highlights {
+				if h.start < end && offset < h.end {
+					fg, bg = h.fg, h.bg
+				}
+			}
+			drawLineNoStatic(cell, x, y, fg, bg)
+			offset = end
+		}
+	}
+}
+
+//drawStatus 最下行に現在位置を表示する
+func (v *MultiLineViewer) drawStatus() {
+	if v.showStatus {
+		drawRowNoStatic(" ", v.height-1, termbox.ColorBlack, defaultFg)
+		drawLineNoStatic(v.statusText(), 0, v.height-1, termbox.ColorBlack, defaultFg)
+	}
+}
+
+//statusText 現在位置の表示。表示中の行と全体の行数、読み終えた割合、情報、残りの読書時間の目安を並べる
+func (v *MultiLineViewer) statusText() string {
+	total, page := len(v.foldedArray), v.pageHeight()
+	if v.vertical {
+		total, page = len(v.columns), v.pageColumns()
+	}
+	status := " " + strconv.Itoa(v.currentLine+1) + "/" + strconv.Itoa(total) + "行  " + strconv.Itoa(v.readPercent()) + "%"
+	if v.statusInfo != "" {
+		status += "  " + v.statusInfo
+	}
+	if v.search.query != "" {
+		status += "  " + v.searchStatus()
+	}
+	remaining := 0
+	for i := v.currentLine + page; i < total; i++ {
+		if v.vertical {
+			remaining += len(v.columns[i])
+			continue
+		}
+		remaining += utf8.RuneCountInString(strings.TrimSpace(v.foldedArray[i]))
+	}
+	minutes := (remaining + readingRunesPerMinute - 1) / readingRunesPerMinute
+	return status + "  残り約" + strconv.Itoa(minutes) + "分"
+}
+
+//readPercent 表示中の画面の最後の行までに読み終えた割合
+func (v *MultiLineViewer) readPercent() int {
+	total, page := len(v.foldedArray), v.pageHeight()
+	if v.vertical {
+		total, page = len(v.columns), v.pageColumns()
+	}
+	if total <= v.currentLine+page {
+		return 100
+	}
+	return (v.currentLine + page) * 100 / total
+}
+
+//pageHeight 本文を表示する行数(縦書きでは一列の文字数)。現在位置を表示する場合は最下行を除く
+func (v *MultiLineViewer) pageHeight() int {
+	if v.showStatus {
+		return v.height - 1
+	}
+	return v.height
+}
+
+//pageColumns 縦書きで一画面に表示する列数
+func (v *MultiLineViewer) pageColumns() int {
+	return v.width / tategakiColumnWidth
+}
+
+//lastLine 表示できる最後の先頭行(縦書きでは列)
+func (v *MultiLineViewer) lastLine() int {
+	if v.vertical {
+		return len(v.columns) - v.pageColumns()
+	}
+	return len(v.foldedArray) - v.pageHeight()
+}
+
+//page 一画面の行数(縦書きでは列数)
+func (v *MultiLineViewer) page() int {
+	if v.vertical {
+		return v.pageColumns()
+	}
+	return v.pageHeight()
+}
+
+//pageForward 縦書きで次の画面へ送る。最後の画面なら右キーの関数(次へ進む処理)を実行する
+func (v *MultiLineViewer) pageForward() {
+	if v.currentLine >= v.lastLine() {
+		v.rightFunc()
+		return
+	}
+	v.SetCurrentLine(v.currentLine + v.pageColumns())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//pageBack 縦書きで前の画面へ戻る。最初の画面なら左キーの関数(前に戻る処理)を実行する
+func (v *MultiLineViewer) pageBack() {
+	if v.currentLine <= 0 {
+		v.leftFunc()
+		return
+	}
+	v.SetCurrentLine(v.currentLine - v.pageColumns())
+	v.moveFunc(v.currentLine)
+	v.Draw()
 }
 
 func (v *MultiLineViewer) moveUp() {
 	if v.currentLine > 0 {
 		v.currentLine--
+		v.moveFunc(v.currentLine)
 	}
 	v.Draw()
 }
 
 func (v *MultiLineViewer) moveDown() {
-	if v.currentLine < len(v.foldedArray)-v.height {
+	if v.currentLine < v.lastLine() {
 		v.currentLine++
+		v.moveFunc(v.currentLine)
 	}
 	v.Draw()
 }
 
 func (v *MultiLineViewer) moveTop() {
 	v.currentLine = 0
+	v.moveFunc(v.currentLine)
+	v.Draw()
 }
 
 func (v *MultiLineViewer) moveBottom() {
-	v.currentLine = len(v.foldedArray) - v.height
+	v.SetCurrentLine(v.lastLine())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//pageDown 一画面下(縦書きでは左)へ移動。最後の画面でも次の話へは進まない
+func (v *MultiLineViewer) pageDown() {
+	v.SetCurrentLine(v.currentLine + v.page())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//pageUp 一画面上(縦書きでは右)へ移動
+func (v *MultiLineViewer) pageUp() {
+	v.SetCurrentLine(v.currentLine - v.page())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//jumpToPercent 全体のpercent%の位置へ移動
+func (v *MultiLineViewer) jumpToPercent(percent int) {
+	total := len(v.foldedArray)
+	if v.vertical {
+		total = len(v.columns)
+	}
+	v.SetCurrentLine(total * percent / 100)
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//inputJumpPercent 移動する位置を最下行で入力する
+func (v *MultiLineViewer) inputJumpPercent() {
+	v.input.Init("移動する位置(0～100%)：", 0, v.height-1)
+	v.input.BackgroundSetting(v.drawPage)
+	v.input.EnterSetting(func(str string) {
+		percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(str), "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			v.Draw() //数値でなければ移動しない
+			return
+		}
+		v.jumpToPercent(percent)
+	})
+	v.input.CancelSetting(v.Draw)
+	v.input.Draw()
+}
+
+//halfPageDown 半画面(縦書きでは半分の列数)下へ移動
+func (v *MultiLineViewer) halfPageDown() {
+	v.SetCurrentLine(v.currentLine + v.halfPage())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//halfPageUp 半画面(縦書きでは半分の列数)上へ移動
+func (v *MultiLineViewer) halfPageUp() {
+	v.SetCurrentLine(v.currentLine - v.halfPage())
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//halfPage 半画面の行数(縦書きでは列数)
+func (v *MultiLineViewer) halfPage() int {
+	if v.vertical {
+		return (v.pageColumns() + 1) / 2
+	}
+	return (v.pageHeight() + 1) / 2
+}
+
+//CurrentLine 現在表示中の先頭行を返す
+func (v *MultiLineViewer) CurrentLine() int {
+	return v.currentLine
+}
+
+//CurrentParagraph 現在表示中の先頭行が、設定された折り返す前の何番目の行かを返す
+func (v *MultiLineViewer) CurrentParagraph() int {
+	if v.currentLine < len(v.lineIndexes) {
+		return v.lineIndexes[v.currentLine]
+	}
+	return 0
+}
+
+//SetCurrentParagraph 設定された折り返す前のparagraph番目の行を先頭に表示する
+func (v *MultiLineViewer) SetCurrentParagraph(paragraph int) {
+	for i, index := range v.lineIndexes {
+		if index >= paragraph {
+			v.SetCurrentLine(i)
+			return
+		}
+	}
+	v.SetCurrentLine(len(v.lineIndexes))
+}
+
+//Resize 画面の大きさに合わせて文字列を設定し直す。表示していた段落が先頭になるように表示位置を合わせる
+func (v *MultiLineViewer) Resize(str []string) {
+	paragraph := v.CurrentParagraph()
+	v.width, v.height = termbox.Size()
+	v.clearLines()
+	v.SetStrings(str)
+	v.SetCurrentParagraph(paragraph)
+	v.search.matches = findMatches(v.lines, v.search.query) //一致した位置は行の中のバイト数なので変わらない
+	v.moveFunc(v.currentLine)
+}
+
+//SetCurrentLine 表示する先頭行を設定する。範囲外なら表示できる位置に収める
+func (v *MultiLineViewer) SetCurrentLine(line int) {
+	if line > v.lastLine() {
+		line = v.lastLine()
+	}
+	if line < 0 {
+		line = 0
+	}
+	v.currentLine = line
+}
+
+//SetMoveFunc 表示行が変わったときに実行される関数を設定
+func (v *MultiLineViewer) SetMoveFunc(f func(line int)) {
+	v.moveFunc = f
 }
 
 //SetLeftRightFunc 関数を設定する
 func (v *MultiLineViewer) SetLeftRightFunc(left, right func()) {
 	v.leftFunc = left
 	v.rightFunc = right
-	//キー押下時の動作を設定
-	SetInputFunction(v.moveUp, v.moveDown, v.leftFunc, v.rightFunc, v.cancelFunc, func() {}, v.moveTop, v.moveBottom)
+	v.setInput()
+}
+
+//SetFoldUnits SetStringsで設定する各行の折り返しの単位を設定する。SetStringsとResizeより前に設定する
+//ルビの親文字と読みのように途中で折り返さない並びを一つの単位にする。nilの行は文字列を一文字ずつに分ける
+func (v *MultiLineViewer) SetFoldUnits(units [][]foldUnit) {
+	v.foldUnits = units
+}
+
+//SetVertical 縦書きにするかを設定する。SetStringsより前に設定する
+//縦書きでは左右キーで画面を送り、最初と最後の画面を越えるとそれぞれ左と右の関数を実行する
+func (v *MultiLineViewer) SetVertical(vertical bool) {
+	v.vertical = vertical
+	v.setInput()
+}
+
+//setInput キー押下時の動作を設定。次の話と前の話へ進む操作は右キーと左キーの関数を実行する
+func (v *MultiLineViewer) setInput() {
+	handlers := map[keyAction]func(){
+		actionUp:              v.moveUp,
+		actionDown:            v.moveDown,
+		actionLeft:            v.leftFunc,
+		actionRight:           v.rightFunc,
+		actionCancel:          v.cancelFunc,
+		actionSelect:          v.enterFunc,
+		actionTop:             v.moveTop,
+		actionBottom:          v.moveBottom,
+		actionHalfPageDown:    v.halfPageDown,
+		actionHalfPageUp:      v.halfPageUp,
+		actionPageDown:        v.pageDown,
+		actionPageUp:          v.pageUp,
+		actionJumpPercent:     v.inputJumpPercent,
+		actionSearchForward:   func() { v.startSearch(false) },
+		actionSearchBackward:  func() { v.startSearch(true) },
+		actionSearchNext:      func() { v.nextMatch(false) },
+		actionSearchPrevious:  func() { v.nextMatch(true) },
+		actionNextEpisode:     v.rightFunc,
+		actionPreviousEpisode: v.leftFunc,
+	}
+	if v.vertical {
+		//縦書きは右から左へ読み進める
+		handlers[actionLeft] = v.pageForward
+		handlers[actionRight] = v.pageBack
+	}
+	SetKeyActions(handlers)
+}
+
+//SetStatus 最下行に現在位置を表示する。infoは現在位置の後に表示する情報。SetStringsより前に設定する
+func (v *MultiLineViewer) SetStatus(info string) {
+	v.showStatus = true
+	v.statusInfo = info
+}
+
+//SetEnterFunc Enterキー押下時の動作を設定
+func (v *MultiLineViewer) SetEnterFunc(f func()) {
+	v.enterFunc = f
+	v.setInput()
 }
 
 //CancelSetting MultiLineViewerにおけるEscキー押下時の動作を設定
 func (v *MultiLineViewer) CancelSetting(f func()) {
 	v.cancelFunc = f
-	//キー押下時の動作を設定
-	SetInputFunction(v.moveUp, v.moveDown, v.leftFunc, v.rightFunc, v.cancelFunc, func() {}, v.moveTop, v.moveBottom)
+	v.setInput()
 }
 
-//SetStrings ビュワーに表示する文字列を設定
+//SetStrings ビュワーに表示する文字列を設定。改行を含む行は改行の位置でも折り返す
 func (v *MultiLineViewer) SetStrings(str []string) {
-	//折り返し行をスライスに追加していく
 	for _, l := range str {
-		fl := stringFold(l, v.width-8)
-		v.foldedArray = append(v.foldedArray, fl...)
+		index := len(v.lines)
+		v.lines = append(v.lines, l)
+		offset := 0
+		for _, part := range strings.Split(l, "\n") {
+			if v.vertical {
+				lengths := tategakiCellLengths(part)
+				for _, column := range tategakiColumns([]string{part}, v.pageHeight()) {
+					v.columns = append(v.columns, column)
+					v.cellLengths = append(v.cellLengths, lengths[:len(column)])
+					lengths = lengths[len(column):]
+					v.lineIndexes = append(v.lineIndexes, index)
+					v.lineOffsets = append(v.lineOffsets, offset)
+					offset += v.rowLength(len(v.columns) - 1)
+				}
+			} else {
+				//折り返し行をスライスに追加していく。折り返した行を繋げると元の行に戻る
+				for _, folded := range v.fold(index, part) {
+					v.foldedArray = append(v.foldedArray, folded)
+					v.lineIndexes = append(v.lineIndexes, index)
+					v.lineOffsets = append(v.lineOffsets, offset)
+					offset += len(folded)
+				}
+			}
+			offset += len("\n")
+		}
+	}
+}
+
+//rowLength 折り返した行(縦書きでは列)に表示する元の文字列のバイト数
+func (v *MultiLineViewer) rowLength(row int) int {
+	if !v.vertical {
+		return len(v.foldedArray[row])
+	}
+	length := 0
+	for _, l := range v.cellLengths[row] {
+		length += l
 	}
+	return length
 }
 
-//stringFold 文字列をwidthに合わせて折り返したものを配列にして返す
+//fold index番目の行のpartを画面幅に合わせて折り返す。折り返しの単位が設定されていればそれを使う
+func (v *MultiLineViewer) fold(index int, part string) []string {
+	w := v.width - 8
+	if runewidth.StringWidth(part) <= w {
+		//文字列の長さが画面幅より短いのならそのまま帰す
+		return []string{part}
+	}
+	if index < len(v.foldUnits) && v.foldUnits[index] != nil && joinUnits(v.foldUnits[index]) == part {
+		return kinsokuFoldUnits(v.foldUnits[index], w)
+	}
+	return stringFold(part, w)
+}
+
+//stringFold 文字列をwidthに合わせて禁則処理をしながら折り返したものを配列にして返す。句読点はwidthを超えてぶら下げる
 func stringFold(str string, w int) []string {
-	var runecon int    //文字数カウンター
-	var lineStr string //一行あたりの文字列
-	var lines []string
-	lcon := runewidth.StringWidth(str)
-	if lcon <= w {
+	if runewidth.StringWidth(str) <= w {
 		//文字列の長さが画面幅より短いのならそのまま帰す
 		return []string{str}
 	}
-	runecon = 0
-	lineStr = ""
-	lines = []string{}
-	for _, r := range str {
-		runecon += runewidth.RuneWidth(r)
-		if runecon <= w {
-			//文字列は画面の幅を超えなかった
-			lineStr += string(r)
-		} else {
-			//文字列は画面の幅を超えてしまっている
-			//lineStrをlines配列に加えて初期化
-			lines = append(lines, lineStr)
-			lineStr = string(r)
-			runecon = runewidth.RuneWidth(r)
-		}
-	}
-	lines = append(lines, lineStr)
-	return lines
+	return kinsokuFold(str, w)
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/nsf/termbox-go"
 )
 
@@ -9,6 +11,8 @@ var (
 	width    int       //画面横幅
 	height   int       //画面縦幅
 	appquiet chan bool //このチャンネルに送信を行うとアプリが終了する
+	//終了する時にキャンセルされる。全ての取得はこれから作ったcontextで行う
+	appContext, cancelApp = context.WithCancel(context.Background())
 )
 
 func run() {
@@ -18,6 +22,10 @@ func run() {
 	defer termbox.Close()
 	termbox.Clear(defaultFg, defaultBg)
 
+	settings, _ := loadHTTPSettings() //読み込みに失敗した場合は初期の設定を使う
+	sharedHTTPClient = newHTTPClient(settings)
+	defer cancelApp() //取得中の通信を中断する
+
 	width, height = termbox.Size()
 	appquiet = make(chan bool)
 	go inputLoop()   //入力待機
@@ -25,5 +33,6 @@ func run() {
 	initChoiceList() //選択肢初期化
 	initView()       //画面構成初期化
 	<-appquiet
+	novelLibrary.save() //しおりを保存
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 }
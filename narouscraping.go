@@ -5,104 +5,187 @@ package main
 //著者やあらすじなどの雑多な情報を取得するのはnovelinformationに任せる
 
 import (
-	"fmt"
+	"context"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 const narouURL string = "http://ncode.syosetu.com" //小説家になろうのURL
+const narouIndexFetchWorkers = 3                   //目次の2ページ目以降を同時に取得するページ数の上限
 
-type narouNovel struct {
-	ncode     string
-	rubiStart string //置き換えられるルビのはじめ
-	rubiEnd   string //置き換えられるルビの閉じ
-}
+var (
+	narouIndexPageRegexp = regexp.MustCompile(`[?&]p=([0-9]+)`) //目次のページ送りのリンクに含まれるページ番号
+	narouStoryRegexp     = regexp.MustCompile(`/([0-9]+)/?$`)   //目次に含まれる各話へのリンク
+)
 
-//小説一話による情報
-type storyInformation struct {
-	number       int    //何話目
-	subTitle     string //サブタイトル
-	chapterTitle string //チャプター名
+//narouIndexPage 目次の一ページから取り出した各話
+type narouIndexPage struct {
+	stories     []storyInformation //各話。ページの最初の章名より前の話は章名が空になる
+	lastChapter string             //ページの最後の章名。章名が無ければ空
 }
 
-//小説構造体を作成
-func newNarouNovel() *narouNovel {
-	return &narouNovel{}
+//fetchIndex 小説家になろうの目次から各話の一覧を取得
+//話数の多い小説の目次は?p=2のように複数のページに分かれているので、最後のページまで取得して繋げる
+func (site *narouSite) fetchIndex(ctx context.Context, ncode string) ([]storyInformation, error) {
+	if err := site.checkEnabled(); err != nil {
+		return nil, err
+	}
+	indexURL := site.url + "/" + ncode + "/"
+	doc, err := getDocument(ctx, indexURL, site.cookies()...)
+	if err != nil {
+		return nil, err
+	}
+	pages := []narouIndexPage{parseNarouIndexPage(doc)}
+	if last := narouIndexLastPage(doc); last > 1 {
+		rest, err := site.fetchIndexPages(ctx, indexURL, last)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, rest...)
+	}
+	return mergeNarouIndexPages(pages), nil
 }
 
-//stories2LinesArray 引数の小説の各話をLines配列に変換
-func stories2LinesArray(stories []storyInformation) []Lines {
-	linesArr := []Lines{}
-	for _, s := range stories {
-		lines := Lines{s.subTitle, s.chapterTitle, ""}
-		linesArr = append(linesArr, lines)
+//fetchIndexPages 目次の2ページ目からlastページ目までを取得する。同時に取得するページ数はnarouIndexFetchWorkersまでにする
+func (site *narouSite) fetchIndexPages(ctx context.Context, indexURL string, last int) ([]narouIndexPage, error) {
+	pages := make([]narouIndexPage, last-1) //ページの順に並べるため、ページ番号の位置に格納する
+	errs := make([]error, last-1)
+	workers := make(chan bool, narouIndexFetchWorkers)
+	var wg sync.WaitGroup
+	for p := 2; p <= last; p++ {
+		wg.Add(1)
+		workers <- true
+		go func(p int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			doc, err := getDocument(ctx, indexURL+"?p="+strconv.Itoa(p), site.cookies()...)
+			if err != nil {
+				errs[p-2] = err
+				return
+			}
+			pages[p-2] = parseNarouIndexPage(doc)
+		}(p)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
-	return linesArr
+	return pages, nil
 }
 
-//初期化
-func (novel *narouNovel) init(ncode, rubiSt, rubiEnd string) {
-	novel.ncode = ncode
-	novel.rubiStart = rubiSt
-	novel.rubiEnd = rubiEnd
+//parseNarouIndexPage 目次の一ページから各話を取り出す。古い形式(.index_box)と現在の形式(.p-eplist)のどちらにも対応する
+func parseNarouIndexPage(doc *goquery.Document) narouIndexPage {
+	page := narouIndexPage{[]storyInformation{}, ""}
+	doc.Find("div.chapter_title, dl.novel_sublist2, div.p-eplist__chapter-title, div.p-eplist__sublist").Each(func(_ int, s *goquery.Selection) {
+		if s.HasClass("chapter_title") || s.HasClass("p-eplist__chapter-title") {
+			//チャプター名
+			page.lastChapter = strings.TrimSpace(s.Text())
+			return
+		}
+		//小説各話
+		number := 0
+		href, _ := s.Find("a").First().Attr("href")
+		if m := narouStoryRegexp.FindStringSubmatch(href); m != nil {
+			number, _ = strconv.Atoi(m[1])
+		}
+		page.stories = append(page.stories, storyInformation{
+			number,
+			strings.TrimSpace(s.Find(".subtitle, .p-eplist__subtitle").Text()),
+			page.lastChapter,
+			storyUpdatedAt(s),
+		})
+	})
+	return page
 }
 
-//小説一覧情報を一気に取得
-func (novel *narouNovel) getIndexByChapter() []storyInformation {
-	doc, err := goquery.NewDocument(narouURL + "/" + novel.ncode + "/")
-	if err != nil {
-		fmt.Println(err)
-	}
-	chapterTitle := ""
-	storyNumCon := 1                //何話目かのカウンター
-	stories := []storyInformation{} //返す値
+//narouIndexLastPage 目次のページ送りから最後のページ番号を取得。ページ送りが無ければ1を返す
+func narouIndexLastPage(doc *goquery.Document) int {
+	last := 1
+	doc.Find(".c-pager a, .novelview_pager a").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		if m := narouIndexPageRegexp.FindStringSubmatch(href); m != nil {
+			if p, err := strconv.Atoi(m[1]); err == nil && p > last {
+				last = p
+			}
+		}
+	})
+	return last
+}
 
-	//各話の情報を収集
-	doc.Find(".index_box").Each(func(_ int, indexbox *goquery.Selection) {
-		//各項目を処理
-		indexbox.Find("div[class ='chapter_title'], dl[class = 'novel_sublist2']").Each(func(_ int, s *goquery.Selection) {
-			attr, _ := s.Attr("class")
-			switch attr {
-			case "chapter_title":
-				//チャプター名
-				chapterTitle = s.Text()
-			case "novel_sublist2":
-				//小説各話
-				story := storyInformation{
-					storyNumCon,
-					s.Find(".subtitle").Text(),
-					chapterTitle,
+//mergeNarouIndexPages 目次の各ページをページの順に繋げる
+//ページの最初の章名より前の話は前のページの最後の章に含める。リンクから話数が分からない話は前の話の次の話数にする
+func mergeNarouIndexPages(pages []narouIndexPage) []storyInformation {
+	stories := []storyInformation{}
+	chapterTitle := ""
+	for _, page := range pages {
+		for _, s := range page.stories {
+			if s.chapterTitle == "" {
+				s.chapterTitle = chapterTitle
+			}
+			if s.number == 0 {
+				s.number = 1
+				if len(stories) > 0 {
+					s.number = stories[len(stories)-1].number + 1
 				}
-				stories = append(stories, story)
-			default:
 			}
-		})
-	})
+			stories = append(stories, s)
+		}
+		if page.lastChapter != "" {
+			chapterTitle = page.lastChapter
+		}
+	}
 	return stories
 }
 
-//getStory 小説を取得。戻り値は行分けされたString配列
-func (novel *narouNovel) getStory(storyNum int) []string {
-	doc, err := goquery.NewDocument(narouURL + "/" + novel.ncode + "/" + strconv.Itoa(storyNum) + "/")
-	var honbunLinesArray []string
+//storyUpdatedAt 目次の各話の項目から掲載日時を取得。改稿されていれば改稿日時を返す
+func storyUpdatedAt(s *goquery.Selection) string {
+	update := s.Find(".long_update, .p-eplist__update")
+	if kaikou, ok := update.Find("span").Attr("title"); ok {
+		//改稿日時は「2017/03/05 18:00 改稿」の形式
+		return strings.TrimSpace(strings.TrimSuffix(kaikou, "改稿"))
+	}
+	return strings.TrimSpace(update.Contents().First().Text())
+}
+
+//fetchStory 小説家になろうの前書き、本文、後書きを取得
+func (site *narouSite) fetchStory(ctx context.Context, ncode string, storyNum int) (*episode, error) {
+	if err := site.checkEnabled(); err != nil {
+		return nil, err
+	}
+	doc, err := getDocument(ctx, site.url+"/"+ncode+"/"+strconv.Itoa(storyNum)+"/", site.cookies()...)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
-	//本文を取得
-	doc.Find("div[id='novel_honbun']").Each(func(_ int, honbun *goquery.Selection) {
-		//ルビに使われる()を置き換えている
-		honbun.Find("rp").Each(func(_ int, rubi *goquery.Selection) {
-			switch rubi.Text() {
-			case "(":
-				rubi = rubi.ReplaceWithHtml(novel.rubiStart)
-			case ")":
-				rubi = rubi.ReplaceWithHtml(novel.rubiEnd)
-			default:
-			}
-		})
-		honbunLinesArray = regexp.MustCompile("\r\n|\n\r|\n|\r").Split(honbun.Text(), -1)
-	})
-	return honbunLinesArray
+	return narouEpisode(doc)
+}
+
+//fetchShortStory 小説家になろうの短編の前書き、本文、後書きを取得。短編は目次のページに本文がある
+func (site *narouSite) fetchShortStory(ctx context.Context, ncode string) (*episode, error) {
+	if err := site.checkEnabled(); err != nil {
+		return nil, err
+	}
+	doc, err := getDocument(ctx, site.url+"/"+ncode+"/", site.cookies()...)
+	if err != nil {
+		return nil, err
+	}
+	return narouEpisode(doc)
+}
+
+//narouEpisode 本文のページから前書き、本文、後書きを取り出す
+func narouEpisode(doc *goquery.Document) (*episode, error) {
+	story := &episode{
+		parseParagraphs(doc.Find("div[id='novel_p']")),
+		parseParagraphs(doc.Find("div[id='novel_honbun']")),
+		parseParagraphs(doc.Find("div[id='novel_a']")),
+	}
+	if story.honbun == nil {
+		return nil, errStoryNotFound
+	}
+	return story, nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+//update trueなら期待する結果のファイルを今の結果で書き直す
+var update = flag.Bool("update", false, "testdataの.goldenファイルを書き直す")
+
+//loadIndexFixture testdata/narouindexに保存した目次のHTMLを読み込む
+func loadIndexFixture(t *testing.T, name string) *goquery.Document {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "narouindex", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+//storiesText 各話を一話一行の文字列にする
+func storiesText(stories []storyInformation) string {
+	var b strings.Builder
+	for _, s := range stories {
+		b.WriteString(strconv.Itoa(s.number) + "\t" + s.subTitle + "\t" + s.chapterTitle + "\t" + s.updatedAt + "\n")
+	}
+	return b.String()
+}
+
+//checkGolden 結果をtestdata/narouindexの.goldenファイルと比べる
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "narouindex", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%sと一致しない\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestParseNarouIndexPage(t *testing.T) {
+	for _, name := range []string{"index_box", "eplist_nopager", "eplist_page1", "eplist_page2", "eplist_page3"} {
+		t.Run(name, func(t *testing.T) {
+			page := parseNarouIndexPage(loadIndexFixture(t, name+".html"))
+			checkGolden(t, name+".golden", "lastChapter\t"+page.lastChapter+"\n"+storiesText(page.stories))
+		})
+	}
+}
+
+func TestNarouIndexLastPage(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"index_box.html", 2},
+		{"eplist_nopager.html", 1},
+		{"eplist_page1.html", 3},
+		{"eplist_page2.html", 3},
+		{"eplist_page3.html", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := narouIndexLastPage(loadIndexFixture(t, tt.name)); got != tt.want {
+				t.Errorf("narouIndexLastPage = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeNarouIndexPages(t *testing.T) {
+	pages := []narouIndexPage{}
+	for _, name := range []string{"eplist_page1.html", "eplist_page2.html", "eplist_page3.html"} {
+		pages = append(pages, parseNarouIndexPage(loadIndexFixture(t, name)))
+	}
+	checkGolden(t, "eplist_merged.golden", storiesText(mergeNarouIndexPages(pages)))
+}
@@ -0,0 +1,95 @@
+package main
+
+//小説家になろうの一般向けサイトとR18サイトの違いをまとめる
+//R18サイトは設定で有効にした場合のみ使用できる
+
+import (
+	"errors"
+	"net/http"
+)
+
+//narouSite 小説家になろうのサイト。novelSourceを実装する
+type narouSite struct {
+	id    string //ライブラリに保存する識別子
+	name  string //サイト名
+	url   string //小説のURL
+	api   string //なろうAPIのURL
+	isR18 bool   //R18サイトならtrue
+}
+
+var (
+	//narouGeneral 小説家になろう
+	narouGeneral = &narouSite{
+		"narou",
+		"小説家になろう",
+		narouURL,
+		narouAPI,
+		false,
+	}
+	//narouR18 ノクターンノベルズ、ムーンライトノベルズ、ミッドナイトノベルズ
+	narouR18 = &narouSite{
+		"narou18",
+		"ノクターン・ムーンライト・ミッドナイト",
+		"http://novel18.syosetu.com",
+		"http://api.syosetu.com/novel18api/api/",
+		true,
+	}
+)
+
+//errR18Disabled 設定で有効にしていないR18サイトから取得しようとした
+var errR18Disabled = errors.New("R18サイトは設定で有効にした場合のみ使用できます")
+
+//nocgenres R18サイトの掲載サイト
+var nocgenres genres = []genre{
+	{1, "ノクターンノベルズ(男性向け)"},
+	{2, "ムーンライトノベルズ(女性向け)"},
+	{3, "ムーンライトノベルズ(BL)"},
+	{4, "ミッドナイトノベルズ(大人向け)"},
+}
+
+//sourceID ライブラリに保存するサイトの識別子
+func (site *narouSite) sourceID() string {
+	return site.id
+}
+
+//siteName 画面に表示するサイト名
+func (site *narouSite) siteName() string {
+	return site.name
+}
+
+//infoFields なろうAPIで取得する小説情報の項目。R18サイトではジャンルの代わりに掲載サイトを取得する
+func (site *narouSite) infoFields() string {
+	if site.isR18 {
+		return "n-t-w-s-ng-k-gf-gl-nt-e-ga-ibl-igl-izk-its-iti-nu"
+	}
+	return "n-t-w-s-bg-g-k-gf-gl-nt-e-ga-ir-ibl-igl-izk-its-iti-nu"
+}
+
+//searchOrders 検索結果の並び順として指定できるもの
+func (site *narouSite) searchOrders() []searchOrder {
+	return narouSearchOrders
+}
+
+//genreFilters 検索で絞り込めるジャンル。R18サイトではジャンルの代わりに掲載サイトで絞り込む
+func (site *narouSite) genreFilters() []genreFilter {
+	if site.isR18 {
+		return []genreFilter{{"nocgenre", nocgenres}}
+	}
+	return []genreFilter{{"biggenre", biggenres}, {"genre", smallgenres}}
+}
+
+//cookies ページを取得する時に付けるCookie。R18サイトでは年齢確認のCookieを付ける
+func (site *narouSite) cookies() []*http.Cookie {
+	if site.isR18 {
+		return []*http.Cookie{{Name: "over18", Value: "yes"}}
+	}
+	return nil
+}
+
+//checkEnabled 設定で使用できないR18サイトならerrR18Disabledを返す。取得の前に呼ぶ
+func (site *narouSite) checkEnabled() error {
+	if !novelLibrary.sourceEnabled(site) {
+		return errR18Disabled
+	}
+	return nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/json"
-	"net/http"
+	"context"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const narouAPI = "http://api.syosetu.com/novelapi/api/" //なろうAPIのURL
 const narouAPITimeLayout = "2006-01-02 15:04:05"        //なろうAPIにおける日付のフォーマット
+const narouAPIMaxLimit = 500                            //なろうAPIで一度に取得できる最大件数
+const narouAPIMaxStart = 2000                           //なろうAPIで指定できる最大の出力開始位置
+
+//errNovelNotFound 指定したNコードの小説が見つからない
+var errNovelNotFound = &fetchError{notFoundError, "小説が見つかりませんでした", "", 0, nil}
 
 //ジャンルの定義構造体
 type genre struct {
@@ -109,31 +114,34 @@ var (
 	}
 )
 
-//novelinformation 小説家になろうの小説情報
+//novelinformation 小説情報。項目はなろうAPIに合わせていて、他のサイトでは取得できる項目のみ設定する
 type novelinformation struct {
 	//基本情報
-	ncode            string    //小説のID
-	title            string    //小説のタイトル
-	author           string    //著者
-	allcount         int       //小説の話数
-	firstpostingdate time.Time //初回掲載日
-	lastpostingdate  time.Time //最終掲載日
-	novelupdatedat   time.Time //小説の更新日時
-	isrensai         bool      //連載作品なら真(短編は偽)
-	isend            bool      //完結済みなら真
-	isr15            bool      //R15作品なら真
-	isbl             bool      //BL作品なら真
-	isgl             bool      //ガールズラブ作品なら真
-	iszankoku        bool      //「残酷な描写あり」なら真
-	istensei         bool      //「異世界転生」なら真
-	istenni          bool      //「異世界転移」なら真
-	synopsis         string    //あらすじ
-	keyword          string    //キーワード
-	biggenre         genre     //大ジャンル
-	smallgenre       genre     //ジャンル
+	site             novelSource //掲載サイト
+	ncode            string      //小説のID
+	title            string      //小説のタイトル
+	author           string      //著者
+	allcount         int         //小説の話数
+	firstpostingdate time.Time   //初回掲載日
+	lastpostingdate  time.Time   //最終掲載日
+	novelupdatedat   time.Time   //小説の更新日時
+	isrensai         bool        //連載作品なら真(短編は偽)
+	isend            bool        //完結済みなら真
+	isr15            bool        //R15作品なら真
+	isbl             bool        //BL作品なら真
+	isgl             bool        //ガールズラブ作品なら真
+	iszankoku        bool        //「残酷な描写あり」なら真
+	istensei         bool        //「異世界転生」なら真
+	istenni          bool        //「異世界転移」なら真
+	synopsis         string      //あらすじ
+	keyword          string      //キーワード
+	biggenre         genre       //大ジャンル
+	smallgenre       genre       //ジャンル
+	nocgenre         genre       //R18サイトの掲載サイト
 	//使用者による情報
 	currentcount int  //現在読んでいる話数
 	islock       bool //更新を行わないならTrue
+	hasupdate    bool //入手後に更新されていたらTrue
 }
 
 //なろうAPIで取得した構造体を小説情報へ挿入するための中間構造体
@@ -141,11 +149,13 @@ type narouAPIjson struct {
 	//タイプ１
 	Allcount int `json:"allcount"` //infoデータの総数
 	//タイプ２
+	Ncode          string `json:"ncode"`
 	Title          string `json:"title"`
 	Writer         string `json:"writer"`
 	Story          string `json:"story"`
 	Biggenre       int    `json:"biggenre"`
 	Genre          int    `json:"genre"`
+	Nocgenre       int    `json:"nocgenre"`
 	Keyword        string `json:"keyword"`
 	GeneralFirstup string `json:"general_firstup"`
 	GeneralLastup  string `json:"general_lastup"`
@@ -172,149 +182,177 @@ type narouAPISearchResultjson struct {
 	Ncode  string `json:"ncode"`
 }
 
-//ResultListStringArray 項目の一覧を取得する
-func ResultListStringArray(v []narouAPISearchResultjson) []Lines {
-	sa := []Lines{}
-	for _, v := range v {
-		sa = append(sa, Lines([]string{v.Title, "作者    　：" + v.Writer, "あらすじ　：" + v.Story, ""}))
+//search 検索条件に合う小説をなろうAPIでst件目からlim件取得する。allcountは検索条件に合う小説の総数
+func (site *narouSite) search(ctx context.Context, filter url.Values, st, lim int) (allcount int, results []searchResult, err error) {
+	if err = site.checkEnabled(); err != nil {
+		return 0, nil, err
+	}
+	//検索条件を書き換えないように複製してから出力形式を指定する
+	values := copyValues(filter)
+	values.Set("gzip", "5")
+	values.Set("out", "json")
+	values.Set("of", "t-n-w-s") //タイトル、Nコード、作者、あらすじを取得
+	values.Set("lim", strconv.Itoa(lim))
+	values.Set("st", strconv.Itoa(st))
+
+	//なろうAPIから情報を取得
+	var resultInfo []narouAPISearchResultjson
+	err = getGzipJSON(ctx, site.api+"?"+values.Encode(), &resultInfo) //jsonを構造体に代入
+	if err != nil {
+		return 0, nil, err
+	}
+	results = []searchResult{}
+	if len(resultInfo) == 0 {
+		return 0, results, nil
+	}
+	for _, r := range resultInfo[1:] { //先頭のAllcountのみの構造体を除外する
+		results = append(results, searchResult{strings.ToLower(r.Ncode), r.Title, r.Writer, r.Story})
 	}
-	return sa
+	return resultInfo[0].Allcount, results, nil
 }
 
 func newNovelinformation() *novelinformation {
-	return &novelinformation{}
+	return &novelinformation{site: narouGeneral}
 }
 
-//init 小説家になろうの小説情報を引数のNコードから入手する。
-func (info *novelinformation) init(ncode string) (*novelinformation, error) {
-	values := url.Values{}
-	var intermediateinfo []narouAPIjson //変換するための中間情報構造体
-	values.Add("gzip", "5")             //gzipで圧縮レベルを5を指定
-	values.Add("out", "json")           //jsonで出力
-	values.Add("ncode", ncode)          //出力するNcodeを指定
-	values.Add("of", "t-w-s-bg-g-k-gf-gl-nt-e-ga-ir-ibl-igl-izk-its-iti-nu")
-
-	resp, err := http.Get(narouAPI + "?" + values.Encode()) //なろうAPIから情報を取得
-
+//init 小説情報を引数のサイトと小説のIDから入手する。
+func (info *novelinformation) init(ctx context.Context, site novelSource, ncode string) (*novelinformation, error) {
+	infos, err := site.fetchInformations(ctx, []string{ncode})
 	if err != nil {
 		return &novelinformation{}, err
 	}
-	defer resp.Body.Close() //終了処理
-
-	//gzipで圧縮されているので解凍する
-	decompbody, err := gzip.NewReader(resp.Body) //解凍のために読み込ませる
-	if err != nil {
-		return &novelinformation{}, err
+	latest, ok := infos[strings.ToLower(ncode)]
+	if !ok {
+		return &novelinformation{}, errNovelNotFound
 	}
-	defer decompbody.Close() //終了処理
+	*info = *latest
+	info.ncode = ncode    //Nコード
+	info.currentcount = 0 //まだしおりを挟んでいない状態
+	info.islock = false   //更新ロックをかけていない状態
 
-	dec := json.NewDecoder(decompbody) //jsonをデコード
-	err = dec.Decode(&intermediateinfo)
-	if err != nil {
-		return &novelinformation{}, err
-	}
+	return info, nil //無事に処理が終了した
+}
 
-	//jsonから読み込まれた中間構造体を扱う形に移植する
-	info.ncode = ncode                                                                              //Nコード
-	info.title = intermediateinfo[1].Title                                                          //タイトル
-	info.author = intermediateinfo[1].Writer                                                        //著者
-	info.allcount = intermediateinfo[1].GeneralAllNo                                                //総話数
-	info.firstpostingdate, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].GeneralFirstup) //初回掲載日時
-	if err != nil {
-		info.firstpostingdate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
-	}
-	info.lastpostingdate, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].GeneralLastup) //最終掲載日時
+//isShort 短編ならtrue。小説情報を取得できていない場合は連載として扱う
+func (info *novelinformation) isShort() bool {
+	return !info.isrensai && info.title != ""
+}
+
+//update 小説情報を掲載サイトから取得して更新する
+func (info *novelinformation) update(ctx context.Context) error {
+	infos, err := info.site.fetchInformations(ctx, []string{info.ncode})
 	if err != nil {
-		info.lastpostingdate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
+		return err
 	}
-	info.novelupdatedat, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].NovelupdatedAt) //小説の更新日時
-	if err != nil {
-		info.novelupdatedat = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
+	latest, ok := infos[strings.ToLower(info.ncode)]
+	if !ok {
+		return errNovelNotFound
 	}
-	info.isrensai = (intermediateinfo[1].NovelType == 1)
-	info.isend = (intermediateinfo[1].End == 0)
-	info.isr15 = (intermediateinfo[1].Isr15 == 1)
-	info.isbl = (intermediateinfo[1].Isbl == 1)
-	info.isgl = (intermediateinfo[1].Isgl == 1)
-	info.iszankoku = (intermediateinfo[1].Iszankoku == 1)
-	info.istensei = (intermediateinfo[1].Istensei == 1)
-	info.istenni = (intermediateinfo[1].Istenni == 1)
-	info.synopsis = intermediateinfo[1].Story  //あらすじ
-	info.keyword = intermediateinfo[1].Keyword //キーワード
-	bg, _ := biggenres.FindID(intermediateinfo[1].Biggenre)
-	info.biggenre = bg
-	sg, _ := smallgenres.FindID(intermediateinfo[1].Genre)
-	info.smallgenre = sg
-	info.currentcount = 0 //まだしおりを挟んでいない状態
-	info.islock = false   //更新ロックをかけていない状態
-
-	return info, nil //無事に処理が終了したs
+	info.applyLatest(latest)
+	return nil
 }
 
-//update 小説家になろうの小説情報を更新する
-func (info *novelinformation) update() {
-	values := url.Values{}
-	var intermediateinfo []narouAPIjson //変換するための中間情報構造体
-	values.Add("gzip", "5")             //gzipで圧縮レベルを5を指定
-	values.Add("out", "json")           //jsonで出力
-	values.Add("ncode", info.ncode)     //出力するNcodeを指定
-	values.Add("of", "t-w-s-bg-g-k-gf-gl-nt-e-ga-ir-ibl-igl-izk-its-iti-nu")
+//isUpdated 最新の小説情報と比べて、話数か更新日時が変わっていればtrue
+func (info *novelinformation) isUpdated(latest *novelinformation) bool {
+	return latest.allcount != info.allcount || !latest.novelupdatedat.Equal(info.novelupdatedat)
+}
 
-	resp, err := http.Get(narouAPI + "?" + values.Encode()) //なろうAPIから情報を取得
+//applyLatest 最新の小説情報を反映する。使用者による情報はそのまま残す
+func (info *novelinformation) applyLatest(latest *novelinformation) {
+	info.title = latest.title
+	info.author = latest.author
+	info.allcount = latest.allcount
+	info.firstpostingdate = latest.firstpostingdate
+	info.lastpostingdate = latest.lastpostingdate
+	info.novelupdatedat = latest.novelupdatedat
+	info.isrensai = latest.isrensai
+	info.isend = latest.isend
+	info.isr15 = latest.isr15
+	info.isbl = latest.isbl
+	info.isgl = latest.isgl
+	info.iszankoku = latest.iszankoku
+	info.istensei = latest.istensei
+	info.istenni = latest.istenni
+	info.synopsis = latest.synopsis
+	info.keyword = latest.keyword
+	info.biggenre = latest.biggenre
+	info.smallgenre = latest.smallgenre
+	info.nocgenre = latest.nocgenre
+	info.hasupdate = false //最新の情報になった
+}
 
-	if err != nil {
-		return
+//fetchInformations 複数の小説情報をなろうAPIからまとめて取得する。戻り値は小文字のNコードをキーとする
+func (site *narouSite) fetchInformations(ctx context.Context, ncodes []string) (map[string]*novelinformation, error) {
+	if err := site.checkEnabled(); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close() //終了処理
+	infos := map[string]*novelinformation{}
+	//一度に取得できる件数ごとにNコードを'-'で繋げて問い合わせる
+	for st := 0; st < len(ncodes); st += narouAPIMaxLimit {
+		end := st + narouAPIMaxLimit
+		if end > len(ncodes) {
+			end = len(ncodes)
+		}
+		values := url.Values{}
+		var intermediateinfo []narouAPIjson                    //変換するための中間情報構造体
+		values.Add("gzip", "5")                                //gzipで圧縮レベルを5を指定
+		values.Add("out", "json")                              //jsonで出力
+		values.Add("ncode", strings.Join(ncodes[st:end], "-")) //出力するNcodeを指定
+		values.Add("lim", strconv.Itoa(end-st))                //指定したNcodeを全て出力
+		values.Add("of", site.infoFields())
 
-	//gzipで圧縮されているので解凍する
-	decompbody, err := gzip.NewReader(resp.Body) //解凍のために読み込ませる
-	if err != nil {
-		return
-	}
-	defer decompbody.Close() //終了処理
+		err := getGzipJSON(ctx, site.api+"?"+values.Encode(), &intermediateinfo) //なろうAPIから情報を取得
+		if err != nil {
+			return infos, err
+		}
 
-	dec := json.NewDecoder(decompbody) //jsonをデコード
-	err = dec.Decode(&intermediateinfo)
-	if err != nil {
-		return
+		//先頭はallcountのみなので除外する
+		for i, j := range intermediateinfo {
+			if i == 0 {
+				continue
+			}
+			info := newNovelinformation()
+			info.site = site
+			info.setAPIjson(j)
+			infos[info.ncode] = info
+		}
 	}
+	return infos, nil
+}
 
-	//jsonから読み込まれた中間構造体を扱う形に移植する
-	info.title = intermediateinfo[1].Title                                                          //タイトル
-	info.author = intermediateinfo[1].Writer                                                        //著者
-	info.allcount = intermediateinfo[1].GeneralAllNo                                                //総話数
-	info.firstpostingdate, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].GeneralFirstup) //初回掲載日時
+//setAPIjson jsonから読み込まれた中間構造体を扱う形に移植する
+func (info *novelinformation) setAPIjson(j narouAPIjson) {
+	var err error
+	info.ncode = strings.ToLower(j.Ncode)                                         //Nコード
+	info.title = j.Title                                                          //タイトル
+	info.author = j.Writer                                                        //著者
+	info.allcount = j.GeneralAllNo                                                //総話数
+	info.firstpostingdate, err = time.Parse(narouAPITimeLayout, j.GeneralFirstup) //初回掲載日時
 	if err != nil {
 		info.firstpostingdate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
 	}
-	info.lastpostingdate, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].GeneralLastup) //最終掲載日時
+	info.lastpostingdate, err = time.Parse(narouAPITimeLayout, j.GeneralLastup) //最終掲載日時
 	if err != nil {
 		info.lastpostingdate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
 	}
-	info.novelupdatedat, err = time.Parse(narouAPITimeLayout, intermediateinfo[1].NovelupdatedAt) //小説の更新日時
+	info.novelupdatedat, err = time.Parse(narouAPITimeLayout, j.NovelupdatedAt) //小説の更新日時
 	if err != nil {
 		info.novelupdatedat = time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local) //取得失敗の場合2000/1/1/0/0/00の日付が代入される
-		err = nil
 	}
-	info.isrensai = (intermediateinfo[1].NovelType == 1)
-	info.isend = (intermediateinfo[1].End == 0)
-	info.isr15 = (intermediateinfo[1].Isr15 == 1)
-	info.isbl = (intermediateinfo[1].Isbl == 1)
-	info.isgl = (intermediateinfo[1].Isgl == 1)
-	info.iszankoku = (intermediateinfo[1].Iszankoku == 1)
-	info.istensei = (intermediateinfo[1].Istensei == 1)
-	info.istenni = (intermediateinfo[1].Istenni == 1)
-	info.synopsis = intermediateinfo[1].Story //あらすじ
-	bg, _ := biggenres.FindID(intermediateinfo[1].Biggenre)
+	info.isrensai = (j.NovelType == 1)
+	info.isend = (j.End == 0)
+	info.isr15 = (j.Isr15 == 1)
+	info.isbl = (j.Isbl == 1)
+	info.isgl = (j.Isgl == 1)
+	info.iszankoku = (j.Iszankoku == 1)
+	info.istensei = (j.Istensei == 1)
+	info.istenni = (j.Istenni == 1)
+	info.synopsis = j.Story  //あらすじ
+	info.keyword = j.Keyword //キーワード
+	bg, _ := biggenres.FindID(j.Biggenre)
 	info.biggenre = bg
-	sg, _ := smallgenres.FindID(intermediateinfo[1].Genre)
+	sg, _ := smallgenres.FindID(j.Genre)
 	info.smallgenre = sg
-	info.currentcount = 0 //まだしおりを挟んでいない状態
-	info.islock = false   //更新ロックをかけていない状態
+	ng, _ := nocgenres.FindID(j.Nocgenre)
+	info.nocgenre = ng
 }
@@ -0,0 +1,156 @@
+package main
+
+//なろうのランキングAPIからランキングを取得する
+//ランキングにはNコードとポイントしか含まれないので、タイトルなどはなろうAPIからまとめて取得して結合する
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const narouRankingAPI = "http://api.syosetu.com/rank/rankget/" //なろうランキングAPIのURL
+const narouRankingDateLayout = "20060102"                      //ランキングAPIにおける日付のフォーマット
+
+//rankingFirstDate ランキングAPIで取得できる最初の日付
+var rankingFirstDate = time.Date(2013, 5, 1, 0, 0, 0, 0, time.Local)
+
+//rankingType ランキングの種類
+type rankingType int
+
+const (
+	//dailyRanking 日間ランキング
+	dailyRanking rankingType = iota
+	//weeklyRanking 週間ランキング
+	weeklyRanking
+	//monthlyRanking 月間ランキング
+	monthlyRanking
+	//quarterRanking 四半期ランキング
+	quarterRanking
+)
+
+//String ランキングの種類の名前を返す
+func (t rankingType) String() string {
+	switch t {
+	case dailyRanking:
+		return "日間"
+	case weeklyRanking:
+		return "週間"
+	case monthlyRanking:
+		return "月間"
+	case quarterRanking:
+		return "四半期"
+	default:
+		return "UnKnown"
+	}
+}
+
+//suffix rtypeに付けるランキングの種類の文字
+func (t rankingType) suffix() string {
+	switch t {
+	case weeklyRanking:
+		return "w"
+	case monthlyRanking:
+		return "m"
+	case quarterRanking:
+		return "q"
+	default:
+		return "d"
+	}
+}
+
+//next 次のランキングの種類
+func (t rankingType) next() rankingType {
+	return (t + 1) % (quarterRanking + 1)
+}
+
+//normalizeDate ランキングの種類に合わせて指定できる日付に直す
+//週間は火曜日、月間と四半期は月初の日付のみ指定できる
+func (t rankingType) normalizeDate(d time.Time) time.Time {
+	d = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local)
+	switch t {
+	case weeklyRanking:
+		return d.AddDate(0, 0, -((int(d.Weekday()) - int(time.Tuesday) + 7) % 7))
+	case monthlyRanking, quarterRanking:
+		return time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.Local)
+	default:
+		return d
+	}
+}
+
+//moveDate ランキングの日付をn期間分動かす
+func (t rankingType) moveDate(d time.Time, n int) time.Time {
+	switch t {
+	case weeklyRanking:
+		return d.AddDate(0, 0, 7*n)
+	case monthlyRanking, quarterRanking:
+		return d.AddDate(0, n, 0)
+	default:
+		return d.AddDate(0, 0, n)
+	}
+}
+
+//rankingItem ランキングの一項目
+type rankingItem struct {
+	rank   int    //順位
+	point  int    //ポイント
+	ncode  string //Nコード
+	title  string //タイトル
+	author string //作者
+}
+
+//ランキングAPIで取得したランキングを代入する構造体
+type narouRankingjson struct {
+	Ncode string `json:"ncode"`
+	Point int    `json:"pt"`
+	Rank  int    `json:"rank"`
+}
+
+//fetchRanking 指定した種類と日付のランキングを取得し、小説の情報と結合して返す
+func fetchRanking(ctx context.Context, t rankingType, date time.Time) ([]rankingItem, error) {
+	values := url.Values{}
+	values.Add("gzip", "5")
+	values.Add("out", "json")
+	values.Add("rtype", t.normalizeDate(date).Format(narouRankingDateLayout)+"-"+t.suffix())
+
+	var rankingInfo []narouRankingjson
+	err := getGzipJSON(ctx, narouRankingAPI+"?"+values.Encode(), &rankingInfo) //ランキングAPIから情報を取得
+	if err != nil {
+		return nil, err
+	}
+
+	//タイトルと作者をまとめて取得して結合する
+	ncodes := []string{}
+	for _, r := range rankingInfo {
+		ncodes = append(ncodes, r.Ncode)
+	}
+	infos, err := narouGeneral.fetchInformations(ctx, ncodes) //ランキングは一般向けサイトのみ
+	if err != nil {
+		return nil, err
+	}
+	items := []rankingItem{}
+	for _, r := range rankingInfo {
+		item := rankingItem{r.Rank, r.Point, strings.ToLower(r.Ncode), "(削除されたか非公開の小説です)", ""}
+		if info, ok := infos[item.ncode]; ok {
+			item.title = info.title
+			item.author = info.author
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+//rankingLinesArray ランキングをLines配列に変換
+func rankingLinesArray(items []rankingItem) []Lines {
+	linesArr := []Lines{}
+	for _, item := range items {
+		linesArr = append(linesArr, Lines{
+			strconv.Itoa(item.rank) + "位　" + item.title,
+			strconv.Itoa(item.point) + "pt　作者：" + item.author,
+			"",
+		})
+	}
+	return linesArr
+}
@@ -0,0 +1,125 @@
+package main
+
+//検索の詳細条件
+//各項目は選択肢を順番に切り替えて指定する
+
+import (
+	"net/url"
+)
+
+//searchOrder 検索結果の並び順
+type searchOrder struct {
+	value string //なろうAPIのorderに指定する値
+	name  string //画面に表示する名前
+}
+
+//narouSearchOrders なろうAPIで指定できる並び順
+var narouSearchOrders = []searchOrder{
+	{"hyoka", "総合評価の高い順"},
+	{"favnovelcnt", "ブックマーク数の多い順"},
+	{"reviewcnt", "レビュー数の多い順"},
+	{"impressioncnt", "感想の多い順"},
+	{"hyokacnt", "評価者数の多い順"},
+	{"weekly", "週間ユニークユーザーの多い順"},
+	{"new", "新着順"},
+	{"old", "古い順"},
+}
+
+//genreFilter ジャンルによる絞り込み
+type genreFilter struct {
+	key    string //なろうAPIのクエリの名前
+	genres genres //指定できるジャンル
+}
+
+//searchOption 詳細条件の項目
+type searchOption struct {
+	name    string               //項目名
+	choices []searchOptionChoice //選択肢。先頭は指定なし
+	current int                  //選択中の選択肢
+}
+
+//searchOptionChoice 詳細条件の選択肢
+type searchOptionChoice struct {
+	name   string     //選択肢の名前
+	values url.Values //この選択肢で追加するなろうAPIのクエリ
+}
+
+//newSearchOptions 詳細条件の項目一覧を作成
+func newSearchOptions() []*searchOption {
+	return []*searchOption{
+		{"作品の種類", []searchOptionChoice{
+			{"指定なし", url.Values{}},
+			{"短編", url.Values{"type": {"t"}}},
+			{"連載中", url.Values{"type": {"r"}}},
+			{"完結済の連載", url.Values{"type": {"er"}}},
+			{"連載作品すべて", url.Values{"type": {"re"}}},
+			{"短編と完結済の連載", url.Values{"type": {"ter"}}},
+		}, 0},
+		{"文字数", []searchOptionChoice{
+			{"指定なし", url.Values{}},
+			{"3万字以下", url.Values{"length": {"-30000"}}},
+			{"3万～10万字", url.Values{"length": {"30000-100000"}}},
+			{"10万～30万字", url.Values{"length": {"100000-300000"}}},
+			{"30万～100万字", url.Values{"length": {"300000-1000000"}}},
+			{"100万字以上", url.Values{"length": {"1000000-"}}},
+		}, 0},
+		{"最終掲載日", []searchOptionChoice{
+			{"指定なし", url.Values{}},
+			{"今週", url.Values{"lastup": {"thisweek"}}},
+			{"先週", url.Values{"lastup": {"lastweek"}}},
+			{"過去7日間", url.Values{"lastup": {"sevenday"}}},
+			{"今月", url.Values{"lastup": {"thismonth"}}},
+			{"先月", url.Values{"lastup": {"lastmonth"}}},
+		}, 0},
+		newTagSearchOption("R15", "isr15", "notr15"),
+		newTagSearchOption("ボーイズラブ", "isbl", "notbl"),
+		newTagSearchOption("ガールズラブ", "isgl", "notgl"),
+		newTagSearchOption("残酷な描写あり", "iszankoku", "notzankoku"),
+		newTagSearchOption("異世界転生", "istensei", "nottensei"),
+		newTagSearchOption("異世界転移", "istenni", "nottenni"),
+	}
+}
+
+//newTagSearchOption 作品の要素を含むか除外するかを指定する項目を作成
+func newTagSearchOption(name, isKey, notKey string) *searchOption {
+	return &searchOption{name, []searchOptionChoice{
+		{"指定なし", url.Values{}},
+		{"含む作品のみ", url.Values{isKey: {"1"}}},
+		{"除外する", url.Values{notKey: {"1"}}},
+	}, 0}
+}
+
+//String 項目名と選択中の選択肢を返す
+func (o *searchOption) String() string {
+	return o.name + "：" + o.choices[o.current].name
+}
+
+//next 次の選択肢に切り替える
+func (o *searchOption) next() {
+	o.current = (o.current + 1) % len(o.choices)
+}
+
+//previous 前の選択肢に切り替える
+func (o *searchOption) previous() {
+	o.current = (o.current + len(o.choices) - 1) % len(o.choices)
+}
+
+//applySearchOptions 検索条件に詳細条件を加えたものを返す(引数の検索条件は書き換えない)
+func applySearchOptions(filter url.Values, options []*searchOption) url.Values {
+	values := copyValues(filter)
+	for _, o := range options {
+		for k, v := range o.choices[o.current].values {
+			values[k] = append([]string{}, v...)
+		}
+	}
+	return values
+}
+
+//copyValues クエリを複製する
+func copyValues(v url.Values) url.Values {
+	values := url.Values{}
+	for k, vv := range v {
+		values[k] = append([]string{}, vv...)
+	}
+	return values
+}
@@ -0,0 +1,146 @@
+package main
+
+//小説を掲載しているサイトの抽象化
+//検索、小説情報、目次、本文の取得をサイトごとに実装する
+//入手した小説には掲載サイトの識別子を記録するので、一つのライブラリに複数のサイトの小説を保存できる
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+//novelSource 小説を掲載しているサイト
+type novelSource interface {
+	//sourceID ライブラリに保存するサイトの識別子
+	sourceID() string
+	//siteName 画面に表示するサイト名
+	siteName() string
+	//search 検索条件に合う小説をst件目からlim件取得する。allcountは検索条件に合う小説の総数
+	search(ctx context.Context, filter url.Values, st, lim int) (allcount int, results []searchResult, err error)
+	//fetchInformations 複数の小説情報をまとめて取得する。戻り値は小文字の小説IDをキーとする
+	fetchInformations(ctx context.Context, ncodes []string) (map[string]*novelinformation, error)
+	//fetchIndex 各話の一覧を取得する
+	fetchIndex(ctx context.Context, ncode string) ([]storyInformation, error)
+	//fetchStory 各話の前書き、本文、後書きを段落に分けて取得する
+	fetchStory(ctx context.Context, ncode string, storyNum int) (*episode, error)
+	//fetchShortStory 短編の前書き、本文、後書きを段落に分けて取得する
+	fetchShortStory(ctx context.Context, ncode string) (*episode, error)
+	//searchOrders 検索結果の並び順として指定できるもの。指定できなければ空
+	searchOrders() []searchOrder
+	//genreFilters 検索で絞り込めるジャンル。指定できなければ空
+	genreFilters() []genreFilter
+}
+
+//errStoryNotFound ページに本文が見つからない
+var errStoryNotFound = &fetchError{notFoundError, "本文が見つかりませんでした", "", 0, nil}
+
+//searchResult 検索結果の一項目
+type searchResult struct {
+	ncode    string //小説のID
+	title    string //タイトル
+	author   string //作者
+	synopsis string //あらすじ
+}
+
+//novelSources 対応しているサイトの一覧
+func novelSources() []novelSource {
+	return []novelSource{narouGeneral, narouR18, hameln}
+}
+
+//searchableSources 検索画面で選べるサイトの一覧。R18サイトは設定で有効にした場合のみ選べる
+func searchableSources() []novelSource {
+	sources := []novelSource{}
+	for _, s := range novelSources() {
+		if novelLibrary.sourceEnabled(s) {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+//sourceFromID 識別子からサイトを返す。見つからなければ小説家になろうを返す
+func sourceFromID(id string) novelSource {
+	for _, s := range novelSources() {
+		if s.sourceID() == id {
+			return s
+		}
+	}
+	return narouGeneral
+}
+
+//nextSource 一覧の中で次(dが負なら前)のサイトを返す
+func nextSource(sources []novelSource, current novelSource, d int) novelSource {
+	for i, s := range sources {
+		if s == current {
+			return sources[(i+d+len(sources))%len(sources)]
+		}
+	}
+	return sources[0]
+}
+
+//ResultListStringArray 項目の一覧を取得する
+func ResultListStringArray(v []searchResult) []Lines {
+	sa := []Lines{}
+	for _, v := range v {
+		sa = append(sa, Lines([]string{v.title, "作者    　：" + v.author, "あらすじ　：" + v.synopsis, ""}))
+	}
+	return sa
+}
+
+//httpGet ページやAPIの応答を共有のクライアントで取得する。cookiesは年齢確認などに使う。応答は呼び出し側で閉じる
+//通信の失敗とエラーの状態はfetchErrorにし、見つからない(404と410)場合はnotFoundErrorにする
+func httpGet(ctx context.Context, pageURL string, cookies ...*http.Cookie) (*http.Response, error) {
+	resp, err := sharedHTTPClient.get(ctx, pageURL, cookies...)
+	if err != nil {
+		return nil, newFetchError(networkError, pageURL, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		fe := newFetchError(httpStatusError, pageURL, nil)
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			fe = newFetchError(notFoundError, pageURL, nil)
+		}
+		fe.status = resp.StatusCode
+		return nil, fe
+	}
+	return resp, nil
+}
+
+//getDocument ページを取得して解析する。cookiesは年齢確認などに使う
+func getDocument(ctx context.Context, pageURL string, cookies ...*http.Cookie) (*goquery.Document, error) {
+	resp, err := httpGet(ctx, pageURL, cookies...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //終了処理
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, newFetchError(parseError, pageURL, err)
+	}
+	return doc, nil
+}
+
+//getGzipJSON gzipで圧縮されたAPIの応答を取得し、JSONをvに読み込む
+func getGzipJSON(ctx context.Context, apiURL string, v interface{}) error {
+	resp, err := httpGet(ctx, apiURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //終了処理
+
+	//gzipで圧縮されているので解凍する
+	decompbody, err := gzip.NewReader(resp.Body) //解凍のために読み込ませる
+	if err != nil {
+		return newFetchError(parseError, apiURL, err)
+	}
+	defer decompbody.Close() //終了処理
+	if err := json.NewDecoder(decompbody).Decode(v); err != nil {
+		return newFetchError(parseError, apiURL, err)
+	}
+	return nil
+}
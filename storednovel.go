@@ -0,0 +1,89 @@
+package main
+
+//入手した小説と読んでいる小説の各話の読み込み
+//掲載サイトを問わず、ローカルに保存されていればそちらを読み込み、無ければサイトから取得する
+
+import (
+	"context"
+)
+
+//storedNovel 各話を読み込む小説。掲載サイトを問わない
+type storedNovel struct {
+	site      novelSource //掲載サイト
+	ncode     string
+	short     bool   //短編ならtrue。目次が無く、本文を一話目として扱う
+	title     string //短編の一話目のサブタイトルにするタイトル
+	updatedAt string //短編の一話目の掲載日時にする更新日時
+}
+
+//小説一話による情報
+type storyInformation struct {
+	number       int    //何話目
+	subTitle     string //サブタイトル
+	chapterTitle string //チャプター名
+	updatedAt    string //掲載日時。改稿されていれば改稿日時
+}
+
+//小説構造体を作成
+func newStoredNovel() *storedNovel {
+	return &storedNovel{}
+}
+
+//stories2LinesArray 引数の小説の各話をLines配列に変換
+func stories2LinesArray(stories []storyInformation) []Lines {
+	linesArr := []Lines{}
+	for _, s := range stories {
+		lines := Lines{s.subTitle, s.chapterTitle, ""}
+		linesArr = append(linesArr, lines)
+	}
+	return linesArr
+}
+
+//初期化
+func (novel *storedNovel) init(info *novelinformation) {
+	novel.site = info.site
+	novel.ncode = info.ncode
+	novel.short = info.isShort()
+	novel.title = info.title
+	novel.updatedAt = info.novelupdatedat.Format(narouAPITimeLayout)
+}
+
+//小説一覧情報を一気に取得。ローカルに保存されていればそちらを読み込む
+func (novel *storedNovel) getIndexByChapter(ctx context.Context) ([]storyInformation, error) {
+	if stories, err := loadIndex(novel.ncode); err == nil {
+		return stories, nil
+	}
+	return novel.fetchIndexByChapter(ctx)
+}
+
+//fetchIndexByChapter 小説一覧情報をサイトから取得。短編は本文だけの一話の小説とする
+//目次に一話も無い場合は、小説が削除されたか目次を解析できなかったのでエラーにする
+func (novel *storedNovel) fetchIndexByChapter(ctx context.Context) ([]storyInformation, error) {
+	if novel.short {
+		return []storyInformation{{1, novel.title, "", novel.updatedAt}}, nil
+	}
+	stories, err := novel.site.fetchIndex(ctx, novel.ncode)
+	if err != nil {
+		return nil, err
+	}
+	if len(stories) == 0 {
+		return nil, &fetchError{notFoundError, "目次に各話が見つかりませんでした", "", 0, nil}
+	}
+	return stories, nil
+}
+
+//getStory 小説を取得。ローカルに保存されていればそちらを読み込む
+func (novel *storedNovel) getStory(ctx context.Context, storyNum int) (*episode, error) {
+	if story, err := loadEpisode(novel.ncode, storyNum); err == nil {
+		return story, nil
+	}
+	return novel.fetchStory(ctx, storyNum)
+}
+
+//fetchStory 小説をサイトから取得
+func (novel *storedNovel) fetchStory(ctx context.Context, storyNum int) (*episode, error) {
+	if novel.short {
+		return novel.site.fetchShortStory(ctx, novel.ncode)
+	}
+	return novel.site.fetchStory(ctx, novel.ncode, storyNum)
+}
@@ -0,0 +1,145 @@
+package main
+
+//縦書き表示のための変換
+//一行の文字列を一文字ずつのマスに分け、画面の高さで区切った列にする
+//縦書き用の字形がある約物は置き換え、半角英数字は全角にする。二桁の数字は縦中横として一マスに収める
+
+const tategakiColumnWidth = 3 //縦書きの一列の幅(全角一文字と行間)
+
+//tategakiRunes 縦書きで置き換える文字
+var tategakiRunes = map[rune]rune{
+	'ー': '丨',
+	'―': '丨',
+	'－': '丨',
+	'─': '│',
+	'～': '≀',
+	'〜': '≀',
+	'…': '︙',
+	'‥': '︰',
+	'、': '︑',
+	'。': '︒',
+	'，': '︐',
+	'．': '︒',
+	'：': '︓',
+	'；': '︔',
+	'！': '︕',
+	'？': '︖',
+	'「': '﹁',
+	'」': '﹂',
+	'『': '﹃',
+	'』': '﹄',
+	'（': '︵',
+	'）': '︶',
+	'｛': '︷',
+	'｝': '︸',
+	'〔': '︹',
+	'〕': '︺',
+	'【': '︻',
+	'】': '︼',
+	'《': '︽',
+	'》': '︾',
+	'〈': '︿',
+	'〉': '﹀',
+	'［': '﹇',
+	'］': '﹈',
+	'＝': '‖',
+}
+
+//tategakiCells 一行の文字列を縦書きの一文字ずつのマスに分ける
+func tategakiCells(line string) []string {
+	cells := []string{}
+	for _, g := range tategakiSplit(line) {
+		if len(g) == 2 {
+			//二桁の数字は縦中横にする
+			cells = append(cells, string([]rune{halfWidthDigit(g[0]), halfWidthDigit(g[1])}))
+			continue
+		}
+		cells = append(cells, string(tategakiRune(g[0])))
+	}
+	return cells
+}
+
+//tategakiCellLengths 各マスに表示する元の文字列のバイト数
+func tategakiCellLengths(line string) []int {
+	lengths := []int{}
+	for _, g := range tategakiSplit(line) {
+		lengths = append(lengths, len(string(g)))
+	}
+	return lengths
+}
+
+//tategakiSplit 一行の文字列を一マスに表示する文字ごとに分ける。二桁の数字は一マスにまとめる
+func tategakiSplit(line string) [][]rune {
+	runes := []rune(line)
+	groups := [][]rune{}
+	for i := 0; i < len(runes); i++ {
+		//数字の続く長さを数える
+		digits := 0
+		for i+digits < len(runes) && isDigit(runes[i+digits]) {
+			digits++
+		}
+		if digits == 2 {
+			groups = append(groups, runes[i:i+2])
+			i++
+			continue
+		}
+		for j := 0; j < digits; j++ {
+			groups = append(groups, runes[i+j:i+j+1])
+		}
+		if digits > 0 {
+			i += digits - 1
+			continue
+		}
+		groups = append(groups, runes[i:i+1])
+	}
+	return groups
+}
+
+//tategakiRune 縦書きで表示する文字に変換する。半角の英数字と記号は全角にしてから置き換える
+func tategakiRune(r rune) rune {
+	switch {
+	case r == ' ':
+		r = '　'
+	case r > ' ' && r <= '~':
+		r += '！' - '!'
+	}
+	if v, ok := tategakiRunes[r]; ok {
+		return v
+	}
+	return r
+}
+
+//isDigit 半角か全角の数字ならtrue
+func isDigit(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= '０' && r <= '９')
+}
+
+//halfWidthDigit 数字を半角にする
+func halfWidthDigit(r rune) rune {
+	if r >= '０' && r <= '９' {
+		return r - '０' + '0'
+	}
+	return r
+}
+
+//tategakiColumns 各行を縦書きの列に変換する。長い行は高さhで区切り、空行は空の列にする
+//画面が低すぎてhが1未満の場合は一文字ずつの列にする
+func tategakiColumns(lines []string, h int) [][]string {
+	if h < 1 {
+		h = 1
+	}
+	columns := [][]string{}
+	for _, l := range lines {
+		cells := tategakiCells(l)
+		if len(cells) == 0 {
+			columns = append(columns, []string{})
+			continue
+		}
+		for len(cells) > h {
+			columns = append(columns, cells[:h])
+			cells = cells[h:]
+		}
+		columns = append(columns, cells)
+	}
+	return columns
+}
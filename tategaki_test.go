@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTategakiColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		h     int
+		want  [][]string
+	}{
+		{"高さで区切る", []string{"あいうえお"}, 2, [][]string{{"あ", "い"}, {"う", "え"}, {"お"}}},
+		{"空行は空の列にする", []string{"あ", "", "い"}, 2, [][]string{{"あ"}, {}, {"い"}}},
+		{"高さが0なら一文字ずつにする", []string{"あいう"}, 0, [][]string{{"あ"}, {"い"}, {"う"}}},
+		{"高さが負でも一文字ずつにする", []string{"あい"}, -1, [][]string{{"あ"}, {"い"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tategakiColumns(tt.lines, tt.h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tategakiColumns(%q, %d) = %q, want %q", tt.lines, tt.h, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+//Text Input
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+//TextInput 一行の文字列を入力するための構造体。IMEで確定した日本語も入力できる
+type TextInput struct {
+	runes      []rune            //入力中の文字列
+	cursor     int               //カーソルの位置(文字数)
+	x          int               //入力欄の描画位置
+	y          int               //入力欄の描画位置
+	label      string            //入力欄の前に表示する文字列
+	enterFunc  func(str string)  //Enterキーが押された時に実行される確定処理
+	cancelFunc func()            //Escキーが押された時に実行されるキャンセル処理
+	changeFunc func(str string)  //入力中の文字列が変わった時に実行される処理
+	background func()            //入力欄と一緒に描画する画面。描画バッファに加えるだけで描画はしない
+	fg         termbox.Attribute //文字の色
+	bg         termbox.Attribute //背景の色
+}
+
+//NewTextInput 作成
+func NewTextInput() *TextInput {
+	return &TextInput{}
+}
+
+//Init 初期化して入力を開始する
+func (t *TextInput) Init(label string, x, y int) {
+	t.runes = []rune{}
+	t.cursor = 0
+	t.x = x
+	t.y = y
+	t.label = label
+	t.enterFunc = func(string) {}
+	t.cancelFunc = func() {}
+	t.changeFunc = func(string) {}
+	t.background = func() {}
+	t.fg = defaultFg
+	t.bg = defaultBg
+	SetTextInputFunction(t.input)
+}
+
+//SetText 入力欄の文字列を設定する
+func (t *TextInput) SetText(str string) {
+	t.runes = []rune(str)
+	t.cursor = len(t.runes)
+}
+
+//EnterSetting Enterキーが押された時の確定処理を設定
+func (t *TextInput) EnterSetting(f func(str string)) {
+	t.enterFunc = f
+}
+
+//CancelSetting Escキーが押された時のキャンセル処理を設定
+func (t *TextInput) CancelSetting(f func()) {
+	t.cancelFunc = f
+}
+
+//ChangeSetting 入力中の文字列が変わった時の処理を設定
+func (t *TextInput) ChangeSetting(f func(str string)) {
+	t.changeFunc = f
+}
+
+//BackgroundSetting 入力欄と一緒に描画する画面を設定。drawLineNoStaticで描画する画面の上に入力欄を表示する時に使う
+func (t *TextInput) BackgroundSetting(f func()) {
+	t.background = f
+}
+
+//Draw 描画
+func (t *TextInput) Draw() {
+	t.background()
+	drawLineNoStatic(t.label+string(t.runes), t.x, t.y, t.fg, t.bg)
+	cursorX := t.x + runewidth.StringWidth(t.label) + runewidth.StringWidth(string(t.runes[:t.cursor]))
+	termbox.SetCursor(cursorX, t.y)
+	drawScreen()
+}
+
+//finish 入力を終了する
+func (t *TextInput) finish() {
+	SetTextInputFunction(nil)
+	termbox.HideCursor()
+}
+
+//input キーイベントを受け取り入力欄を編集する
+func (t *TextInput) input(ev termbox.Event) {
+	before := string(t.runes)
+	switch ev.Key {
+	case termbox.KeyEnter:
+		t.finish()
+		t.enterFunc(string(t.runes))
+		return
+	case termbox.KeyEsc:
+		t.finish()
+		t.cancelFunc()
+		return
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if t.cursor > 0 {
+			t.runes = append(t.runes[:t.cursor-1], t.runes[t.cursor:]...)
+			t.cursor--
+		}
+	case termbox.KeyDelete:
+		if t.cursor < len(t.runes) {
+			t.runes = append(t.runes[:t.cursor], t.runes[t.cursor+1:]...)
+		}
+	case termbox.KeyArrowLeft:
+		if t.cursor > 0 {
+			t.cursor--
+		}
+	case termbox.KeyArrowRight:
+		if t.cursor < len(t.runes) {
+			t.cursor++
+		}
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		t.cursor = 0
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		t.cursor = len(t.runes)
+	case termbox.KeySpace:
+		t.insert(' ')
+	default:
+		if ev.Ch != 0 {
+			//IMEで確定した文字もここに一文字ずつ届く
+			t.insert(ev.Ch)
+		}
+	}
+	if string(t.runes) != before {
+		t.changeFunc(string(t.runes))
+	}
+	t.Draw()
+}
+
+//insert カーソルの位置に文字を挿入する
+func (t *TextInput) insert(r rune) {
+	t.runes = append(t.runes[:t.cursor], append([]rune{r}, t.runes[t.cursor:]...)...)
+	t.cursor++
+}
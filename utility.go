@@ -42,19 +42,15 @@ func drawScreen() {
 }
 
 //Clear 内部バッファを消去
+//失敗しても次の描画で描き直されるので、アプリを終了させずに続ける
 func Clear() {
-	err := termbox.Clear(defaultFg, defaultBg)
-	if err != nil {
-		panic(err)
-	}
+	termbox.Clear(defaultFg, defaultBg)
 }
 
 //Draw 描画を行う（Flush処理）
+//失敗しても次の描画で描き直されるので、アプリを終了させずに続ける
 func Draw() {
-	err := termbox.Flush()
-	if err != nil {
-		panic(err)
-	}
+	termbox.Flush()
 }
 
 //drawScreenWithBuffer drawBuffer構造体を描画
@@ -85,10 +81,7 @@ func drawLine(s string, x, y int, fg, bg termbox.Attribute) {
 		termbox.SetCell(x+ni, y, r, fg, bg)
 		ni = i
 	}
-	err := termbox.Flush()
-	if err != nil {
-		panic(err)
-	}
+	Draw()
 	screenBuffer = append(screenBuffer, drawBuffer{x, y, s, fg, bg})
 }
 
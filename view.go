@@ -1,12 +1,11 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"context"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nsf/termbox-go"
 )
@@ -16,13 +15,18 @@ var (
 	defaultBg   termbox.Attribute //選択肢のバックグラウンド
 	currentmode *viewer           //現在表示中の構造体
 
-	topView                   *topview
-	managementdlView          *managementdlview
-	searchmenuView            *searchmenuview            //検索条件を指定
-	searchmenufiltergenreView *searchmenufiltergenreview //検索ジャンルを指定
-	searchresultView          *searchresultview
-	noveltopView              *noveltopview
-	novelviewerView           *novelview
+	topView                    *topview
+	managementdlView           *managementdlview
+	managementnovelView        *managementnovelview
+	searchmenuView             *searchmenuview             //検索条件を指定
+	searchmenufiltergenreView  *searchmenufiltergenreview  //検索ジャンルを指定
+	searchmenufilteroptionView *searchmenufilteroptionview //検索の詳細条件を指定
+	searchwordView             *searchwordview             //検索する文字列を指定
+	searchresultView           *searchresultview
+	rankingView                *rankingview
+	noveltopView               *noveltopview
+	novelviewerView            *novelview
+	errorView                  *errorview //取得に失敗した理由を表示
 )
 
 //ScreenType 画面のタイプ
@@ -30,12 +34,16 @@ type ScreenType int
 
 //各画面の名称
 const (
-	Top            ScreenType = iota
-	ManagementOfDL ScreenType = iota
-	SearchMenu     ScreenType = iota
-	SearchResult   ScreenType = iota
-	NovelTop       ScreenType = iota
-	NovelView      ScreenType = iota
+	Top             ScreenType = iota
+	ManagementOfDL  ScreenType = iota
+	ManagementNovel ScreenType = iota
+	SearchMenu      ScreenType = iota
+	SearchWord      ScreenType = iota
+	SearchOption    ScreenType = iota
+	SearchResult    ScreenType = iota
+	Ranking         ScreenType = iota
+	NovelTop        ScreenType = iota
+	NovelView       ScreenType = iota
 )
 
 func (s ScreenType) String() string {
@@ -44,10 +52,18 @@ func (s ScreenType) String() string {
 		return "Top"
 	case ManagementOfDL:
 		return "ManagementOfDL"
+	case ManagementNovel:
+		return "ManagementNovel"
 	case SearchMenu:
 		return "SearchMenu"
+	case SearchWord:
+		return "SearchWord"
+	case SearchOption:
+		return "SearchOption"
 	case SearchResult:
 		return "SearchResult"
+	case Ranking:
+		return "Ranking"
 	case NovelTop:
 		return "NovelTop"
 	case NovelView:
@@ -59,17 +75,27 @@ func (s ScreenType) String() string {
 
 //トップ画面構造体
 type topview struct {
-	downloading bool //ダウンロード中ならオン
+	downloading  bool   //ダウンロード中ならオン
+	updateResult string //更新確認の結果
 }
 
 //DL作品管理画面構造体
 type managementdlview struct {
+	message string //画面下部に表示するメッセージ
+}
+
+//DL作品操作画面構造体
+type managementnovelview struct {
+	novelInfo     *novelinformation //操作する小説の情報
+	confirmDelete bool              //削除の確認中ならtrue
+	message       string            //画面下部に表示するメッセージ
 }
 
 //検索画面構造体
 type searchmenuview struct {
-	searchFilter url.Values //検索条件を指定するクエリを保存、追加していく
-	searchString string     //何についてを検索条件として指定するか記述して、表示する
+	searchFilter url.Values  //検索条件を指定するクエリを保存、追加していく
+	searchString string      //何についてを検索条件として指定するか記述して、表示する
+	site         novelSource //検索するサイト
 }
 
 //検索ジャンル指定画面構造体
@@ -78,30 +104,93 @@ type searchmenufiltergenreview struct {
 	searchString string
 }
 
+//検索詳細条件指定画面構造体
+type searchmenufilteroptionview struct {
+	searchFilter url.Values      //検索条件を指定するクエリを保存追加する
+	searchString string          //何についてを検索条件として指定するか記述して、表示する
+	options      []*searchOption //詳細条件
+	notword      string          //除外するキーワード
+	cursor       int             //選択中の項目(選択肢を切り替えた後も位置を保つ)
+	inputNotword bool            //除外するキーワードを入力中ならtrue
+}
+
+//キーワード検索画面構造体
+type searchwordview struct {
+	searchFilter url.Values       //検索条件を指定するクエリを保存追加する
+	searchString string           //何についてを検索条件として指定するか記述して、表示する
+	target       searchWordTarget //何を対象に検索するか
+	word         string           //入力された検索する文字列
+	inputNotword bool             //除外する文字列を入力中ならtrue
+}
+
+//searchWordTarget キーワード検索の対象
+type searchWordTarget int
+
+const (
+	//titleTarget タイトルで検索
+	titleTarget searchWordTarget = iota
+	//writerTarget 作者名で検索
+	writerTarget
+)
+
 //検索結果画面構造体
 type searchresultview struct {
-	searchFilter url.Values                 //なろうAPIの検索文字列
-	searchString string                     //検索条件について記述した文字列
-	resultList   []narouAPISearchResultjson //検索結果
-	updateResult bool                       //trueの時情報を更新
+	site         novelSource    //検索するサイト
+	searchFilter url.Values     //なろうAPIの検索文字列
+	searchString string         //検索条件について記述した文字列
+	resultList   []searchResult //検索結果
+	updateResult bool           //trueの時情報を更新
+	previousView viewer         //戻るときに表示する画面
+	page         int            //表示中のページ(0から始まる)
+	allcount     int            //検索条件に合う小説の総数
+	message      string         //画面下部に表示するメッセージ
+	savedName    string         //保存した検索条件で検索した場合はその名前
+	inputName    bool           //検索条件を保存する名前を入力中ならtrue
+}
+
+const searchResultPerPage = 50 //検索結果の1ページあたりの件数
+
+//ランキング画面構造体
+type rankingview struct {
+	rankType     rankingType   //ランキングの種類
+	date         time.Time     //ランキングの日付
+	rankingList  []rankingItem //ランキング
+	updateResult bool          //trueの時情報を更新
+	message      string        //画面下部に表示するメッセージ
 }
 
 //小説トップ画面構造体
 type noveltopview struct {
-	ncode        string //入手するNCode
+	site         novelSource //掲載サイト
+	ncode        string      //入手するNCode
 	title        string
 	novelInfo    *novelinformation //表示する小説の情報
-	novelStories *narouNovel
-	storiesIndex []storyInformation
+	novelStories *storedNovel
+	storiesIndex episodeIndex
+	loadedNcode  string //情報と目次を取得済みの小説のNCode。画面を作り直す時は取得し直さない
+	previousView viewer //戻るときに表示する画面
 }
 
 //小説表示画面構造体
 type novelview struct {
-	novelInfo    *novelinformation
-	novelStories *narouNovel
-	storyInfo    *storyInformation
-	ncode        string
-	currentnum   int //現在話数
+	novelInfo      *novelinformation
+	novelStories   *storedNovel
+	storyInfo      *storyInformation
+	storiesIndex   episodeIndex //前後の話へ移動するための目次
+	story          *episode     //取得した本文。nilなら画面を作る時に取得する
+	ncode          string
+	currentnum     int  //現在話数(目次の各話の番号)
+	startLine      int  //表示を開始する行
+	startParagraph int  //表示を開始する段落(折り返す前の行)。負ならstartLineを使う
+	notesOpen      bool //畳んだ前書きと後書きを開いているならtrue
+}
+
+//エラー画面構造体
+type errorview struct {
+	title string //何の取得に失敗したか
+	err   error  //失敗した理由
+	retry func() //再試行する時の処理
+	back  func() //戻る時の処理
 }
 
 //画面表示インターフェース
@@ -116,42 +205,117 @@ func initView() {
 	defaultBg = termbox.ColorDefault
 	topView = &topview{
 		false,
+		"",
+	}
+	managementdlView = &managementdlview{
+		"",
+	}
+	managementnovelView = &managementnovelview{
+		&novelinformation{},
+		false,
+		"",
 	}
-	managementdlView = &managementdlview{}
 	searchmenuView = &searchmenuview{
 		url.Values{},
 		"検索条件を決めてください。",
+		narouGeneral,
 	}
 	searchmenufiltergenreView = &searchmenufiltergenreview{
 		url.Values{},
 		"",
 	}
+	searchmenufilteroptionView = &searchmenufilteroptionview{
+		url.Values{},
+		"",
+		newSearchOptions(),
+		"",
+		0,
+		false,
+	}
+	searchwordView = &searchwordview{
+		url.Values{},
+		"",
+		titleTarget,
+		"",
+		false,
+	}
 	searchresultView = &searchresultview{
+		narouGeneral,
 		url.Values{},
 		"",
-		[]narouAPISearchResultjson{},
+		[]searchResult{},
 		true,
+		nil,
+		0,
+		0,
+		"",
+		"",
+		false,
+	}
+	rankingView = &rankingview{
+		dailyRanking,
+		time.Now().AddDate(0, 0, -1), //当日のランキングはまだ集計されていないことがあるので前日
+		[]rankingItem{},
+		true,
+		"",
 	}
 	noveltopView = &noveltopview{
+		narouGeneral,
 		"",
 		"",
 		&novelinformation{},
-		&narouNovel{},
-		[]storyInformation{},
+		&storedNovel{},
+		episodeIndex{},
+		"",
+		nil,
 	}
 	novelviewerView = &novelview{
 		&novelinformation{},
-		&narouNovel{},
+		&storedNovel{},
 		&storyInformation{},
+		episodeIndex{},
+		nil,
 		"",
 		0,
+		0,
+		-1,
+		false,
 	}
+	errorView = &errorview{
+		"",
+		nil,
+		func() {},
+		func() {},
+	}
+
+	novelDownloader = newDownloader()
 
-	SetView(topView) //トップ画面を設定
+	openLibrary() //一覧を読み込んでトップ画面を設定
+}
+
+//openLibrary 小説の一覧を読み込んでトップ画面を表示する
+//読み込めなかった場合はエラー画面で、読み込み直すか、元のファイルを残したまま保存しない空の一覧で続けるかを選ぶ
+func openLibrary() {
+	var err error
+	novelLibrary, err = loadLibrary()
+	if err != nil {
+		showError("小説の一覧を読み込めませんでした。戻ると保存しない空の一覧で続けます", err, openLibrary, func() { SetView(topView) })
+		return
+	}
+	SetView(topView)
 }
 
 //SetView 引数の画面に切り替える
+//画面の大きさが変わった時は選択中の項目を残したまま画面を作り直す。画面ごとに変える場合はturnviewの中で設定し直す
 func SetView(set viewer) {
+	SetResizeFunction(func() {
+		cursor := currentCursor
+		set.turnview()
+		if listLen() > 0 {
+			setCurrentCursor(cursor)
+			drawChoiceList()
+		}
+	})
 	set.turnview()
 }
 
@@ -170,6 +334,33 @@ func (view *topview) turnview() {
 		case 1:
 			//入手した小説を読む
 			SetView(managementdlView)
+		case 2:
+			//ランキングを見る
+			rankingView.updateResult = true
+			SetView(rankingView)
+		case 3:
+			//入手した小説の更新を確認する。小説の一覧への反映は取得が終わってから行う
+			ncodes := novelLibrary.updateTargets()
+			var latests map[string]*novelinformation
+			startFetch("更新を確認中です", func(ctx context.Context) (err error) {
+				latests, err = fetchLatestInformations(ctx, ncodes)
+				return err
+			}, func(err error) {
+				updated := []*novelinformation{}
+				if err == nil {
+					updated, err = novelLibrary.applyUpdates(latests)
+				}
+				view.updateResult = updateResultString(updated, err)
+				for _, info := range updated {
+					if hasLocalCopy(info.ncode) {
+						novelDownloader.download(info) //新しい話と改稿された話だけを取得する
+					}
+				}
+				SetView(view)
+			}, func() {
+				view.updateResult = "更新の確認を中断しました"
+				SetView(view)
+			})
 		default:
 			//その他
 		}
@@ -183,11 +374,13 @@ func (view *topview) turnview() {
 	setStrings([]string{
 		"小説を探す",
 		"入手した小説を読む",
+		"ランキングを見る",
+		"入手した小説の更新を確認する",
 	})
 	setExecute(topmenu)
 	cancelSetting(true, "終了", cancelSelection)
 	setPattern(pat3)
-	setSection(5, height-5)
+	setSection(5, height-7)
 
 	//描画
 	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
@@ -195,107 +388,311 @@ func (view *topview) turnview() {
 	drawLine("なろうが読みたい！は「小説家になろう」を閲覧、保存する非公式コンソールビュワーです。", 0, 2, defaultFg, defaultBg)
 	drawLine("このソフトを使用して生じた損害や責任の一切を製作者は保証できませんのでご注意ください。", 0, 3, defaultFg, defaultBg)
 	drawChoiceList()
+	drawLine(view.updateResult, 0, height-2, defaultFg, defaultBg)
 	var dlFinishStr string
 	if view.downloading {
 		//ダウンロード中
-		dlFinishStr = "ダウンロード中です"
+		dlFinishStr = "ダウンロード中です：" + novelDownloader.Status()
 	} else {
-		//ダウンロード完了
-		dlFinishStr = "ダウンロードが完了しました"
+		//最後に行ったダウンロードの結果
+		dlFinishStr = novelDownloader.Status()
+	}
+	drawLine(dlFinishStr, 0, height-1, defaultFg, defaultBg)
+}
+
+//updateResultString 更新確認の結果を表示する文字列にする
+func updateResultString(updated []*novelinformation, err error) string {
+	if err != nil {
+		return "更新の確認に失敗しました：" + err.Error()
+	}
+	if len(updated) == 0 {
+		return "更新された小説はありません"
 	}
-	drawLine(dlFinishStr, 0, height, defaultFg, defaultBg)
+	titles := []string{}
+	for _, info := range updated {
+		titles = append(titles, info.title+"("+strconv.Itoa(info.allcount)+"話)")
+	}
+	return strconv.Itoa(len(updated)) + "作品に更新があります：" + strings.Join(titles, "、")
 }
 
 //DL管理画面
 func (view *managementdlview) turnview() {
+	//画面構成定義
+	initDraw()
+	initChoiceList()
+
+	selectNovel := func(num int) {
+		view.message = ""
+		managementnovelView.novelInfo = novelLibrary.novels[num]
+		managementnovelView.confirmDelete = false
+		managementnovelView.message = ""
+		SetView(managementnovelView) //小説の操作画面へ
+	}
 
+	cancelSelection := func() {
+		view.message = ""
+		SetView(topView)
+	}
+
+	setMultipleLines(libraryLinesArray(novelLibrary.novels))
+	setExecute(selectNovel)
+	cancelSetting(true, "トップ画面に戻る", cancelSelection)
+	setPattern(pat2)
+	setSection(4, height-5)
+
+	//描画
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawLine("入手した小説を読む", 0, 1, defaultFg, defaultBg)
+	drawLine(strconv.Itoa(len(novelLibrary.novels))+"作品を入手済み", 0, 2, defaultFg, defaultBg)
+	drawRow("=", 3, defaultFg, defaultBg)
+	drawChoiceList()
+	drawLine(view.message, 0, height-1, defaultFg, defaultBg)
 }
 
-//検索メニュー
-func (view *searchmenuview) turnview() {
+//DL作品操作画面
+func (view *managementnovelview) turnview() {
 	//画面構成定義
 	initDraw()
 	initChoiceList()
+	info := view.novelInfo
 
-	//検索画面のメニューを定義
-	searchMenu := func(num int) {
+	//操作後に一覧を保存して画面を描き直す
+	saveAndRefresh := func(message string) {
+		if err := novelLibrary.save(); err != nil {
+			message = "保存に失敗しました：" + err.Error()
+		}
+		view.message = message
+		SetView(view)
+	}
+
+	//取得を中断した時は何も変えずに描き直す
+	cancelFetch := func() {
+		view.message = "中断しました"
+		SetView(view)
+	}
+
+	//削除の確認
+	if view.confirmDelete {
+		deleteNovel := func(num int) {
+			novelDownloader.remove(info.ncode) //ダウンロード中の本文が削除した後に保存されないようにする
+			novelLibrary.remove(info.ncode)
+			novelLibrary.removeBookmark(info.ncode)
+			if err := removeLocalCopy(info.ncode); err != nil {
+				managementdlView.message = "保存した本文の削除に失敗しました：" + err.Error()
+				SetView(managementdlView)
+				return
+			}
+			if err := novelLibrary.save(); err != nil {
+				managementdlView.message = "保存に失敗しました：" + err.Error()
+			} else {
+				managementdlView.message = info.title + "を削除しました"
+			}
+			SetView(managementdlView)
+		}
+		cancelDelete := func() {
+			view.confirmDelete = false
+			SetView(view)
+		}
+		setStrings([]string{"削除する"})
+		setExecute(deleteNovel)
+		cancelSetting(true, "やめる", cancelDelete)
+		setPattern(pat3)
+		setSection(5, height-5)
+
+		//描画
+		drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+		drawRow("=", 1, defaultFg, defaultBg)
+		drawLine(info.title, 0, 2, defaultFg, defaultBg)
+		drawLine("この小説を削除しますか？", 0, 3, defaultFg, defaultBg)
+		drawRow("=", 4, defaultFg, defaultBg)
+		drawChoiceList()
+		return
+	}
+
+	lockString := "更新ロックをかける"
+	if info.islock {
+		lockString = "更新ロックを外す"
+	}
+
+	selectMenu := func(num int) {
 		switch num {
 		case 0:
-			//総合評価の高い順
-			view.searchFilter.Add("order", "hyoka")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "総合評価の多い順"
-			SetView(searchmenufiltergenreView) //ジャンル指定画面へ
-
+			//読む
+			noveltopView.site = info.site
+			noveltopView.ncode = info.ncode
+			noveltopView.title = info.title
+			noveltopView.previousView = view
+			SetView(noveltopView)
 		case 1:
-			//ブックマーク数の多い順
-			view.searchFilter.Add("order", "favnovelcnt")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "ブックマーク数の多い順"
-			SetView(searchmenufiltergenreView)
-
+			//更新を確認する
+			if info.islock {
+				saveAndRefresh("更新ロック中です")
+				return
+			}
+			site, ncode := info.site, info.ncode
+			latest := newNovelinformation()
+			startFetch(info.title+"の更新を確認中です", func(ctx context.Context) error {
+				_, err := latest.init(ctx, site, ncode)
+				return err
+			}, func(err error) {
+				if err != nil {
+					saveAndRefresh("更新の確認に失敗しました：" + err.Error())
+					return
+				}
+				info.hasupdate = info.hasupdate || info.isUpdated(latest)
+				if info.hasupdate {
+					saveAndRefresh("更新があります")
+				} else {
+					saveAndRefresh("更新はありません")
+				}
+			}, cancelFetch)
 		case 2:
-			//レビューの数の多い順
-			view.searchFilter.Add("order", "reviewcnt")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "レビューの数の多い順"
-			SetView(searchmenufiltergenreView)
-
+			//最新の情報に更新する
+			if info.islock {
+				saveAndRefresh("更新ロック中です")
+				return
+			}
+			latest := *info //取得中は小説の一覧の情報に触らないように写しを更新する
+			startFetch(info.title+"の情報を取得中です", func(ctx context.Context) error {
+				return latest.update(ctx)
+			}, func(err error) {
+				if err != nil {
+					saveAndRefresh("更新に失敗しました：" + err.Error())
+					return
+				}
+				info.applyLatest(&latest)
+				if hasLocalCopy(info.ncode) {
+					info.hasupdate = true //更新分をダウンロードするまでは更新ありとする
+					novelDownloader.download(info)
+					saveAndRefresh("最新の情報に更新し、更新分のダウンロードを開始しました")
+					return
+				}
+				saveAndRefresh("最新の情報に更新しました")
+			}, cancelFetch)
 		case 3:
-			//感想の多い順
-			view.searchFilter.Add("order", "impressioncnt")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "感想の多い順"
-			SetView(searchmenufiltergenreView)
-
+			//更新ロックの切り替え
+			info.islock = !info.islock
+			saveAndRefresh("")
 		case 4:
-			//評価者数の多い順
-			view.searchFilter.Add("order", "hyokacnt")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "評価者数の多い順"
-			SetView(searchmenufiltergenreView)
-
+			//ダウンロードする
+			novelDownloader.download(info)
+			saveAndRefresh("ダウンロードを開始しました")
 		case 5:
-			//週間ユニークユーザーの多い順
-			view.searchFilter.Add("order", "weekly")
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "週間ユニークユーザーの多い順"
-			SetView(searchmenufiltergenreView)
+			//削除する
+			view.confirmDelete = true
+			SetView(view)
+		default:
+		}
+	}
 
-		case 6:
-			//新着順
-			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "新着順"
+	cancelSelection := func() {
+		view.message = ""
+		SetView(managementdlView)
+	}
 
-		case 7:
-			//古い順
-			view.searchFilter.Add("order", "old")
+	setStrings([]string{
+		"読む",
+		"更新を確認する",
+		"最新の情報に更新する",
+		lockString,
+		"ダウンロードする",
+		"削除する",
+	})
+	setExecute(selectMenu)
+	cancelSetting(true, "一覧に戻る", cancelSelection)
+	setPattern(pat3)
+	setSection(6, height-7)
+
+	//描画
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawRow("=", 1, defaultFg, defaultBg)
+	drawLine(info.title, 0, 2, defaultFg, defaultBg)
+	drawLine("作者："+info.author, 0, 3, defaultFg, defaultBg)
+	drawLine(libraryLinesArray([]*novelinformation{info})[0][2], 0, 4, defaultFg, defaultBg)
+	drawRow("=", 5, defaultFg, defaultBg)
+	drawChoiceList()
+	drawLine(view.message, 0, height-1, defaultFg, defaultBg)
+}
+
+//検索メニュー
+func (view *searchmenuview) turnview() {
+	//画面構成定義
+	initDraw()
+	initChoiceList()
+
+	//検索画面のメニューを定義。並び順はサイトが指定できるものだけを並べる
+	//保存した検索条件は設定で使用できるサイトのものだけを並べる
+	orders := view.site.searchOrders()
+	searches := []savedSearch{}
+	for _, saved := range novelLibrary.searches {
+		if novelLibrary.sourceEnabled(saved.site) {
+			searches = append(searches, saved)
+		}
+	}
+	searchMenu := func(num int) {
+		switch {
+		case num < len(orders):
+			//並び順を指定してジャンル指定画面へ
+			view.searchFilter.Add("order", orders[num].value)
 			searchmenufiltergenreView.searchFilter = view.searchFilter
-			searchmenufiltergenreView.searchString = "古い順"
+			searchmenufiltergenreView.searchString = orders[num].name
+			SetView(searchmenufiltergenreView)
+
+		case num == len(orders):
+			//タイトルで検索
+			searchwordView.searchFilter = view.searchFilter
+			searchwordView.searchString = "タイトルで検索"
+			searchwordView.target = titleTarget
+			searchwordView.word = ""
+			searchwordView.inputNotword = false
+			SetView(searchwordView) //検索文字列入力画面へ
 
-		case 8:
-		//タイトルで検索
-		case 9:
+		case num == len(orders)+1:
 			//作者名で検索
+			searchwordView.searchFilter = view.searchFilter
+			searchwordView.searchString = "作者名で検索"
+			searchwordView.target = writerTarget
+			searchwordView.word = ""
+			searchwordView.inputNotword = false
+			SetView(searchwordView)
 		default:
+			//保存した検索条件で検索
+			saved := searches[num-len(orders)-2]
+			searchresultView.site = saved.site
+			searchresultView.searchFilter = copyValues(saved.filter)
+			searchresultView.searchString = saved.searchString
+			searchresultView.savedName = saved.name
+			searchresultView.updateResult = true
+			searchresultView.previousView = view
+			SetView(searchresultView) //検索結果へ
 		}
 	}
 	cancelSelection := func() {
 		view.searchFilter = url.Values{} //検索条件を初期化
+		view.site = narouGeneral
 		SetView(topView)
 	}
-	setStrings([]string{
-		"総合評価の高い順",
-		"ブックマーク数の多い順",
-		"レビュー数の多い順",
-		"感想の多い順",
-		"評価者数の多い順",
-		"週間ユニークユーザーの多い順",
-		"新着順",
-		"古い順",
-		"タイトルで検索",
-		"作者名で検索",
-	})
+
+	//左右キーで検索するサイトを切り替える。R18サイトは設定で有効な場合のみ選べる
+	sources := searchableSources()
+	changeSite := func(d int) {
+		view.site = nextSource(sources, view.site, d)
+		SetView(view)
+	}
+	setLeftRightExecute(func() { changeSite(-1) }, func() { changeSite(1) })
+	siteString := "検索対象：" + view.site.siteName() + "(左右キーで切り替え)"
+	if len(orders) == 0 {
+		siteString += "　並び順とジャンルは指定できません"
+	}
+	menuStrings := []string{}
+	for _, o := range orders {
+		menuStrings = append(menuStrings, o.name)
+	}
+	menuStrings = append(menuStrings, "タイトルで検索", "作者名で検索")
+	for _, saved := range searches {
+		menuStrings = append(menuStrings, "保存した検索："+saved.name)
+	}
+	setStrings(menuStrings)
 	setExecute(searchMenu)
 	cancelSetting(true, "トップ画面に戻る", cancelSelection)
 	setPattern(pat3)
@@ -303,7 +700,7 @@ func (view *searchmenuview) turnview() {
 
 	//描画
 	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
-	drawLine("小説を探す。", 0, 1, defaultFg, defaultBg)
+	drawLine("小説を探す。"+siteString, 0, 1, defaultFg, defaultBg)
 	drawLine(view.searchString, 0, 2, defaultFg, defaultBg)
 	drawRow("=", 3, defaultFg, defaultBg)
 	drawChoiceList()
@@ -316,40 +713,31 @@ func (view *searchmenufiltergenreview) turnview() {
 	initDraw()
 	initChoiceList()
 	var AllGenresStringArray []string
+	filters := searchmenuView.site.genreFilters() //サイトが指定できるジャンル
 
 	//検索画面のジャンル指定メニューを定義
 	selectMenu := func(num int) {
 		//numから配列の文字列を取得し、検索してジャンルを取得する
 		str := AllGenresStringArray[num]
-		res1, ok1 := biggenres.FindName(str)
-		res2, ok2 := smallgenres.FindName(str)
-
 		if str == "全てのジャンル" {
 			//全てのジャンルで検索
-			searchresultView.searchFilter = view.searchFilter
-			searchresultView.searchString = view.searchString + "/" + str
-			searchresultView.updateResult = true
-			SetView(searchresultView) //検索結果へ
-		} else if ok1 {
-			//大ジャンルで見つかった時
-			//ジャンル指定を追加
-			view.searchFilter.Add("biggenre", strconv.Itoa(res1.id))
-			searchresultView.searchFilter = view.searchFilter
-			searchresultView.searchString = view.searchString + "/" + res1.genreName
-			searchresultView.updateResult = true
-			SetView(searchresultView)
-		} else if ok2 {
-			//少ジャンルで見つかった時
-			//ジャンル指定を追加
-			view.searchFilter.Add("genre", strconv.Itoa(res2.id))
-			searchresultView.searchFilter = view.searchFilter
-			searchresultView.searchString = view.searchString + "/" + res2.genreName
-			searchresultView.updateResult = true
-			SetView(searchresultView)
-		} else {
-			//大小ジャンルでも検索が見つからないならエラーを表示
-			drawLine("ジャンル指定ができませんでした。", 0, height, defaultFg, defaultBg)
+			searchmenufilteroptionView.searchFilter = view.searchFilter
+			searchmenufilteroptionView.searchString = view.searchString + "/" + str
+			SetView(searchmenufilteroptionView) //詳細条件指定画面へ
+			return
 		}
+		for _, f := range filters {
+			if g, ok := f.genres.FindName(str); ok {
+				//見つかったジャンルの種類に合わせてジャンル指定を追加
+				view.searchFilter.Add(f.key, strconv.Itoa(g.id))
+				searchmenufilteroptionView.searchFilter = view.searchFilter
+				searchmenufilteroptionView.searchString = view.searchString + "/" + g.genreName
+				SetView(searchmenufilteroptionView)
+				return
+			}
+		}
+		//どのジャンルでも見つからないならエラーを表示
+		drawLine("ジャンル指定ができませんでした。", 0, height, defaultFg, defaultBg)
 	}
 
 	cancelSelection := func() {
@@ -358,8 +746,10 @@ func (view *searchmenufiltergenreview) turnview() {
 		searchmenuView.searchFilter.Del("order")
 		SetView(searchmenuView)
 	}
-	AllGenresStringArray = append([]string{"全てのジャンル"}, getGenreStringArray(biggenres)...)
-	AllGenresStringArray = append(AllGenresStringArray, getGenreStringArray(smallgenres)...)
+	AllGenresStringArray = []string{"全てのジャンル"}
+	for _, f := range filters {
+		AllGenresStringArray = append(AllGenresStringArray, getGenreStringArray(f.genres)...)
+	}
 	setStrings(AllGenresStringArray)
 	setExecute(selectMenu)
 	cancelSetting(true, "戻る", cancelSelection)
@@ -374,89 +764,401 @@ func (view *searchmenufiltergenreview) turnview() {
 	drawChoiceList()
 }
 
+//検索詳細条件指定画面
+func (view *searchmenufilteroptionview) turnview() {
+	//画面構成定義
+	initDraw()
+	initChoiceList()
+
+	//除外するキーワードの入力
+	if view.inputNotword {
+		input := NewTextInput()
+		input.Init("除外するキーワード：", 0, 5)
+		input.SetText(view.notword)
+		input.EnterSetting(func(str string) {
+			view.notword = strings.TrimSpace(str)
+			view.inputNotword = false
+			SetView(view)
+		})
+		input.CancelSetting(func() {
+			view.inputNotword = false
+			SetView(view)
+		})
+
+		//描画
+		drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+		drawLine("小説を探す", 0, 1, defaultFg, defaultBg)
+		drawLine(view.searchString, 0, 2, defaultFg, defaultBg)
+		drawRow("=", 3, defaultFg, defaultBg)
+		drawLine("除外するキーワードを空白で区切って入力してください。", 0, 4, defaultFg, defaultBg)
+		input.Draw()
+		return
+	}
+
+	searchExe := len(view.options)      //この条件で検索する項目
+	notwordExe := len(view.options) + 1 //除外するキーワードの項目
+
+	//選択中の詳細条件を切り替える
+	changeOption := func(next bool) {
+		if currentCursor >= len(view.options) {
+			return
+		}
+		if next {
+			view.options[currentCursor].next()
+		} else {
+			view.options[currentCursor].previous()
+		}
+		view.cursor = currentCursor
+		SetView(view)
+	}
+
+	selectMenu := func(num int) {
+		view.cursor = num
+		switch num {
+		case searchExe:
+			//この条件で検索する
+			filter := applySearchOptions(view.searchFilter, view.options)
+			if view.notword != "" {
+				filter.Set("notword", view.notword)
+			}
+			searchresultView.searchFilter = filter
+			searchresultView.searchString = view.searchString
+			searchresultView.updateResult = true
+			searchresultView.previousView = view
+			searchresultView.site = searchmenuView.site
+			SetView(searchresultView) //検索結果へ
+		case notwordExe:
+			//除外するキーワードを入力する
+			view.inputNotword = true
+			SetView(view)
+		default:
+			changeOption(true)
+		}
+	}
+
+	cancelSelection := func() {
+		view.cursor = 0
+		view.searchString = ""
+		view.searchFilter.Del("genre")
+		view.searchFilter.Del("biggenre")
+		view.searchFilter.Del("nocgenre")
+		SetView(searchmenufiltergenreView)
+	}
+
+	items := []string{}
+	for _, o := range view.options {
+		items = append(items, o.String())
+	}
+	items = append(items, "この条件で検索する", "除外するキーワード："+view.notword)
+	setStrings(items)
+	setExecute(selectMenu)
+	cancelSetting(true, "戻る", cancelSelection)
+	setLeftRightExecute(func() { changeOption(false) }, func() { changeOption(true) })
+	setPattern(pat2)
+	setSection(5, height-5)
+	setCurrentCursor(view.cursor)
+
+	//描画
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawLine("小説を探す", 0, 1, defaultFg, defaultBg)
+	drawLine(view.searchString, 0, 2, defaultFg, defaultBg)
+	drawLine("詳細条件をEnterキーか左右キーで切り替えてください。", 0, 3, defaultFg, defaultBg)
+	drawRow("=", 4, defaultFg, defaultBg)
+	drawChoiceList()
+}
+
+//キーワード検索画面
+func (view *searchwordview) turnview() {
+	//画面構成定義
+	initDraw()
+	initChoiceList()
+	input := NewTextInput()
+
+	if !view.inputNotword {
+		//検索する文字列を入力
+		input.Init("検索する文字列：", 0, 5)
+		input.SetText(view.word)
+		input.EnterSetting(func(str string) {
+			if strings.TrimSpace(str) == "" {
+				SetView(view) //空欄では検索できないので入力し直す
+				return
+			}
+			view.word = str
+			view.inputNotword = true
+			SetView(view) //除外する文字列の入力へ
+		})
+		input.CancelSetting(func() {
+			view.word = ""
+			view.searchString = ""
+			SetView(searchmenuView)
+		})
+	} else {
+		//除外する文字列を入力
+		input.Init("除外する文字列：", 0, 6)
+		input.EnterSetting(func(str string) {
+			view.searchFilter.Set("word", view.word)
+			switch view.target {
+			case titleTarget:
+				view.searchFilter.Set("title", "1") //タイトルを検索対象にする
+			case writerTarget:
+				view.searchFilter.Set("wname", "1") //作者名を検索対象にする
+			}
+			if strings.TrimSpace(str) != "" {
+				view.searchFilter.Set("notword", str)
+			}
+			view.inputNotword = false
+			searchresultView.searchFilter = view.searchFilter
+			searchresultView.searchString = view.searchString + "/「" + view.word + "」"
+			searchresultView.updateResult = true
+			searchresultView.previousView = view
+			searchresultView.site = searchmenuView.site
+			SetView(searchresultView) //検索結果へ
+		})
+		input.CancelSetting(func() {
+			view.inputNotword = false
+			SetView(view) //検索する文字列の入力に戻る
+		})
+	}
+
+	//描画
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawLine("小説を探す", 0, 1, defaultFg, defaultBg)
+	drawLine(view.searchString, 0, 2, defaultFg, defaultBg)
+	drawRow("=", 3, defaultFg, defaultBg)
+	if view.inputNotword {
+		drawLine("除外する文字列を空白で区切って入力してください。空欄のままEnterキーで除外せずに検索します。", 0, 4, defaultFg, defaultBg)
+		drawLine("検索する文字列："+view.word, 0, 5, defaultFg, defaultBg)
+	} else {
+		drawLine("検索する文字列を入力してEnterキーで決定してください。Escキーで戻ります。", 0, 4, defaultFg, defaultBg)
+	}
+	input.Draw()
+}
+
 //検索結果
 func (view *searchresultview) turnview() {
 	//画面構成定義
 	initChoiceList()
 	initDraw()
 
+	cancelSelection := func() {
+		//ジャンル指定画面か文字列検索画面で追加された条件を取り除いて戻る
+		for _, key := range []string{"genre", "biggenre", "nocgenre", "word", "title", "wname", "notword"} {
+			view.searchFilter.Del(key)
+		}
+		view.searchString = ""
+		view.updateResult = true
+		view.page = 0
+		view.allcount = 0
+		view.savedName = ""
+		SetView(view.previousView)
+	}
+
 	//フラグがオンのとき検索更新が行われる
 	if view.updateResult {
-		drawLine(view.searchString+"を取得中。", 0, 0, defaultFg, defaultBg)
-		//一覧を取得
-		values := view.searchFilter
-		view.resultList = []narouAPISearchResultjson{}   //検索結果を代入する構造体
-		resultInfoSimple := []narouAPISearchResultjson{} //検索結果単体
-		var pos int                                      //現在位置
-		maxNum := 50                                     //取得数
-		values.Add("gzip", "5")
-		values.Add("out", "json")
-		values.Add("of", "t-n-w-s") //タイトル、Nコード、作者を取得
-		values.Add("lim", "50")     //50件出力
-
-		for pos = 1; pos <= maxNum; pos++ {
-			//一つ一つ取得していき、ランキングの順番を保証する
-			values.Add("st", strconv.Itoa(pos)) //ランキングの順位
-
-			//なろうAPIから情報を取得
-			resp, err := http.Get(narouAPI + "?" + values.Encode())
+		//一ページ分をまとめて取得。取得中に検索条件が変わらないように写しを渡す
+		site, filter, st := view.site, copyValues(view.searchFilter), view.page*searchResultPerPage+1
+		var allcount int
+		var results []searchResult
+		startFetch(view.searchString+"を取得中。", func(ctx context.Context) (err error) {
+			allcount, results, err = site.search(ctx, filter, st, searchResultPerPage)
+			return err
+		}, func(err error) {
 			if err != nil {
-				fmt.Println(err)
+				//updateResultはオンのままにして、再試行する時に取得し直す。戻る時はページと検索条件を元に戻す
+				showError("検索結果を取得できませんでした", err, func() { SetView(view) }, cancelSelection)
+				return
 			}
-			defer resp.Body.Close() //終了処理
+			view.allcount = allcount
+			view.resultList = results
+			view.message = ""
+			view.updateResult = false
+			SetView(view)
+		}, cancelSelection)
+		return
+	}
 
-			//gzipで圧縮されているので解凍する
-			decompbody, err := gzip.NewReader(resp.Body) //解凍のために読み込ませる
-			if err != nil {
-				fmt.Println(err)
+	//ページの移動
+	lastPage := (view.allcount - 1) / searchResultPerPage //最後のページ
+	if maxPage := (narouAPIMaxStart - 1) / searchResultPerPage; lastPage > maxPage {
+		lastPage = maxPage //なろうAPIで取得できる範囲まで
+	}
+	previousPage := func() {
+		if view.page > 0 {
+			view.page--
+			view.updateResult = true
+			SetView(view)
+		}
+	}
+	nextPage := func() {
+		if view.page < lastPage {
+			view.page++
+			view.updateResult = true
+			SetView(view)
+		}
+	}
+
+	//検索条件の保存か、保存した検索条件の削除を先頭に追加する
+	menu := []Lines{{"この検索条件を保存する", ""}}
+	if view.savedName != "" {
+		menu = []Lines{{"この検索条件を保存から削除する", "保存した名前：" + view.savedName, ""}}
+	}
+
+	selectNovels := func(num int) {
+		if num < len(menu) {
+			if view.savedName != "" {
+				//保存した検索条件を削除する
+				novelLibrary.removeSearch(view.savedName)
+				view.message = view.savedName + "を削除しました"
+				view.savedName = ""
+				if err := novelLibrary.save(); err != nil {
+					view.message = "保存に失敗しました：" + err.Error()
+				}
+			} else {
+				//名前の入力へ
+				view.inputName = true
 			}
-			defer decompbody.Close() //終了処理
+			SetView(view)
+			return
+		}
+		num -= len(menu)
+		selectedNovel := view.resultList[num] //小説情報を取得
+		noveltopView.site = view.site
+		noveltopView.ncode = selectedNovel.ncode //小説情報を代入
+		noveltopView.title = selectedNovel.title
+		noveltopView.previousView = view
+		SetView(noveltopView)
+	}
+
+	setMultipleLines(append(menu, ResultListStringArray(view.resultList)...)) //小説を表示
+	setExecute(selectNovels)                                                  //表示関数
+	cancelSetting(true, "戻る", cancelSelection)
+	setLeftRightExecute(previousPage, nextPage)
+	setPattern(pat2)
+	setSection(4, height-5)
+
+	//描画
+	var pageString string
+	if view.page > 0 {
+		pageString += "←前のページ　"
+	}
+	pageString += strconv.Itoa(view.page+1) + "/" + strconv.Itoa(lastPage+1) + "ページ"
+	if view.page < lastPage {
+		pageString += "　次のページ→"
+	}
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawLine(view.searchString+" 検索結果", 0, 1, defaultFg, defaultBg)
+	drawLine(strconv.Itoa(view.allcount)+"件中 "+strconv.Itoa(view.page*searchResultPerPage+1)+"～"+strconv.Itoa(view.page*searchResultPerPage+len(view.resultList))+"件目を表示　"+pageString, 0, 2, defaultFg, defaultBg)
+	drawRow("=", 3, defaultFg, defaultBg)
+	drawChoiceList()
+	if !view.inputName {
+		drawLine(view.message, 0, height-1, defaultFg, defaultBg)
+		return
+	}
 
-			dec := json.NewDecoder(decompbody)  //jsonをデコード
-			err = dec.Decode(&resultInfoSimple) //jsonを構造体に代入
+	//検索条件を保存する名前を入力
+	input := NewTextInput()
+	input.Init("保存する名前：", 0, height-1)
+	input.SetText(view.searchString)
+	input.EnterSetting(func(str string) {
+		view.inputName = false
+		name := strings.TrimSpace(str)
+		if name == "" {
+			SetView(view)
+			return
+		}
+		novelLibrary.addSearch(view.site, name, view.searchString, view.searchFilter)
+		view.message = name + "として保存しました"
+		view.savedName = name
+		if err := novelLibrary.save(); err != nil {
+			view.message = "保存に失敗しました：" + err.Error()
+		}
+		SetView(view)
+	})
+	input.CancelSetting(func() {
+		view.inputName = false
+		SetView(view)
+	})
+	input.Draw()
+}
+
+//ランキング
+func (view *rankingview) turnview() {
+	//画面構成定義
+	initChoiceList()
+	initDraw()
+	view.date = view.rankType.normalizeDate(view.date)
+
+	//フラグがオンのときランキングを取得する
+	if view.updateResult {
+		backToTop := func() {
+			view.updateResult = true //次に開いた時に取得し直す
+			SetView(topView)
+		}
+		rankType, date := view.rankType, view.date
+		var items []rankingItem
+		startFetch(rankType.String()+"ランキングを取得中。", func(ctx context.Context) (err error) {
+			items, err = fetchRanking(ctx, rankType, date)
+			return err
+		}, func(err error) {
 			if err != nil {
-				fmt.Println(err)
+				showError(rankType.String()+"ランキングを取得できませんでした", err, func() { SetView(view) }, backToTop)
+				return
 			}
-			view.resultList = append(view.resultList, resultInfoSimple[1]) //Allcountのみの構造体を除外し、情報を追加していく
-			values.Del("st")
+			view.rankingList = items
+			view.message = ""
+			view.updateResult = false
+			SetView(view)
+		}, backToTop)
+		return
+	}
 
-			//情報ロード画面の進捗状況を描画
-			Clear()
-			drawLineNoStatic("ロード中…"+strconv.Itoa(pos)+"/"+strconv.Itoa(maxNum), 0, 1, defaultFg, defaultBg)
-			drawScreen()
+	//日付の移動
+	moveDate := func(n int) {
+		date := view.rankType.moveDate(view.date, n)
+		if date.Before(rankingFirstDate) || date.After(time.Now()) {
+			return //ランキングが存在しない日付
 		}
-
-		initDraw() //ロード画面消去
+		view.date = date
+		view.updateResult = true
+		SetView(view)
 	}
 
 	selectNovels := func(num int) {
-		selectedNovel := view.resultList[num]    //小説情報を取得
-		noveltopView.ncode = selectedNovel.Ncode //小説情報を代入
-		noveltopView.title = selectedNovel.Title
+		if num == 0 {
+			//ランキングの種類を切り替える
+			view.rankType = view.rankType.next()
+			view.updateResult = true
+			SetView(view)
+			return
+		}
+		selectedNovel := view.rankingList[num-1]
+		noveltopView.site = narouGeneral
+		noveltopView.ncode = selectedNovel.ncode
+		noveltopView.title = selectedNovel.title
+		noveltopView.previousView = view
 		SetView(noveltopView)
 	}
 
 	cancelSelection := func() {
-		view.searchFilter.Del("genre")
-		view.searchFilter.Del("biggenre")
-		view.searchString = ""
-		view.updateResult = true
-		searchmenufiltergenreView.searchFilter.Del("genre")
-		searchmenufiltergenreView.searchFilter.Del("biggenre")
-		SetView(searchmenufiltergenreView)
+		view.message = ""
+		SetView(topView)
 	}
-	setMultipleLines(ResultListStringArray(view.resultList)) //小説を表示
-	setExecute(selectNovels)                                 //表示関数
-	cancelSetting(true, "戻る", cancelSelection)
+
+	menu := []Lines{{"種類：" + view.rankType.String() + "ランキング(Enterで切り替え)", ""}}
+	setMultipleLines(append(menu, rankingLinesArray(view.rankingList)...))
+	setExecute(selectNovels)
+	cancelSetting(true, "トップ画面に戻る", cancelSelection)
+	setLeftRightExecute(func() { moveDate(-1) }, func() { moveDate(1) })
 	setPattern(pat2)
-	setSection(4, height-4)
+	setSection(4, height-5)
 
 	//描画
 	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
-	drawLine(view.searchString+" 検索結果", 0, 1, defaultFg, defaultBg)
-	drawLine(strconv.Itoa(len(view.resultList))+"件表示", 0, 2, defaultFg, defaultBg)
+	drawLine(view.rankType.String()+"ランキング　"+view.date.Format("2006年01月02日"), 0, 1, defaultFg, defaultBg)
+	drawLine("←前の日付　次の日付→", 0, 2, defaultFg, defaultBg)
 	drawRow("=", 3, defaultFg, defaultBg)
 	drawChoiceList()
+	drawLine(view.message, 0, height-1, defaultFg, defaultBg)
 }
 
 //小説詳細トップ
@@ -464,39 +1166,127 @@ func (view *noveltopview) turnview() {
 	//画面構成定義
 	initChoiceList()
 	initDraw()
-	drawLine(view.title+"を取得中。", 0, 0, defaultFg, defaultBg)
-	//情報取得
-	view.novelInfo = newNovelinformation()
-	view.novelInfo.init(view.ncode)
-	view.novelStories = newNarouNovel()
-	view.novelStories.init(view.ncode, "《", "》") //Nコードとルビを設定
-	view.storiesIndex = view.novelStories.getIndexByChapter()
-
-	//読込終了
-	initDraw()
+	selectCancel := func() {
+		view.ncode = ""
+		view.loadedNcode = ""
+		view.title = ""
+		view.novelInfo = newNovelinformation()
+		view.novelStories = newStoredNovel()
+		searchresultView.updateResult = false
+		SetView(view.previousView)
+	}
+	retry := func() {
+		SetView(view)
+	}
 
-	selectStories := func(num int) {
+	//情報と目次を取得する。画面の大きさが変わった時や各話から戻った時は取得済みのものを使う
+	if view.loadedNcode != view.ncode {
+		site, ncode, title := view.site, view.ncode, view.title
+		var novel *storedNovel
+		info := novelLibrary.find(ncode)
+		if info != nil {
+			//入手済みの小説は保存されている情報を使う
+			novel = newStoredNovel()
+			novel.init(info) //サイトとNコードを設定
+		}
+		var stories []storyInformation
+		errTitle := "" //どちらの取得に失敗したか
+		startFetch(title+"を取得中。", func(ctx context.Context) (err error) {
+			if novel == nil {
+				info = newNovelinformation()
+				if _, err = info.init(ctx, site, ncode); err != nil {
+					errTitle = title + "の情報を取得できませんでした"
+					return err
+				}
+				novel = newStoredNovel()
+				novel.init(info) //サイトとNコードを設定
+			}
+			if stories, err = novel.getIndexByChapter(ctx); err != nil {
+				errTitle = novel.title + "の目次を取得できませんでした"
+			}
+			return err
+		}, func(err error) {
+			if err != nil {
+				showError(errTitle, err, retry, selectCancel)
+				return
+			}
+			view.novelInfo = info
+			view.novelStories = novel
+			view.storiesIndex = episodeIndex(stories)
+			view.loadedNcode = ncode
+			SetView(view)
+		}, selectCancel)
+		return
+	}
+
+	//各話を表示する
+	openStory := func(story storyInformation, line, paragraph int) {
 		novelviewerView.ncode = view.novelInfo.ncode
-		novelviewerView.currentnum = num + 1 //閲覧話数をセット
+		novelviewerView.currentnum = story.number //閲覧話数をセット
+		novelviewerView.startLine = line
+		novelviewerView.startParagraph = paragraph
+		novelviewerView.notesOpen = false
 		novelviewerView.novelInfo = view.novelInfo
 		novelviewerView.novelStories = view.novelStories
-		novelviewerView.storyInfo = &view.storiesIndex[num+1]
+		novelviewerView.storyInfo = &story
+		novelviewerView.storiesIndex = view.storiesIndex
+		novelviewerView.story = nil
 		SetView(novelviewerView)
 	}
 
-	selectCancel := func() {
-		view.ncode = ""
-		view.title = ""
-		view.novelInfo = newNovelinformation()
-		view.novelStories = newNarouNovel()
-		searchresultView.updateResult = false
-		SetView(searchresultView)
+	//しおりがあれば続きから読む項目を、入手していない小説なら入手する項目を先頭に追加する
+	menu := []Lines{}
+	menuExe := []func(){}
+	if b, ok := novelLibrary.bookmark(view.ncode); ok {
+		if story, ok := view.storiesIndex.find(b.episode); ok {
+			menu = append(menu, Lines{"続きから読む", strconv.Itoa(b.episode) + "話　" + story.subTitle, ""})
+			menuExe = append(menuExe, func() {
+				openStory(story, b.line, b.paragraph)
+			})
+		} else if story, ok := view.storiesIndex.next(b.episode); ok {
+			//しおりを挟んだ話が削除された場合は、その次の話の最初から読む
+			menu = append(menu, Lines{"続きから読む", strconv.Itoa(story.number) + "話　" + story.subTitle, ""})
+			menuExe = append(menuExe, func() {
+				openStory(story, 0, -1)
+			})
+		}
+	}
+	episodes := stories2LinesArray(view.storiesIndex)
+	if view.novelInfo.isShort() && len(view.storiesIndex) > 0 {
+		//短編は目次を表示せず、本文を開く項目だけにする
+		episodes = []Lines{}
+		menu = append(menu, Lines{"本文を読む", ""})
+		menuExe = append(menuExe, func() {
+			openStory(view.storiesIndex[0], 0, -1)
+		})
+	}
+	if novelLibrary.find(view.ncode) == nil {
+		menu = append(menu, Lines{"この小説を入手する", ""})
+		menuExe = append(menuExe, func() {
+			//小説を入手してダウンロードする
+			novelLibrary.add(view.novelInfo)
+			if err := novelLibrary.save(); err != nil {
+				novelLibrary.remove(view.ncode)
+				drawLine("入手に失敗しました："+err.Error(), 0, height-1, defaultFg, defaultBg)
+				return
+			}
+			novelDownloader.download(view.novelInfo)
+			SetView(view)
+		})
+	}
+
+	selectStories := func(num int) {
+		if num < len(menu) {
+			menuExe[num]()
+			return
+		}
+		openStory(view.storiesIndex[num-len(menu)], 0, -1)
 	}
 
 	setExecute(selectStories)
 	cancelSetting(true, "小説一覧に戻る", selectCancel)
 	setPattern(pat2)
-	setMultipleLines(stories2LinesArray(view.storiesIndex))
+	setMultipleLines(append(menu, episodes...))
 	setSection(6, height-6)
 
 	//描画
@@ -515,70 +1305,290 @@ func (view *novelview) turnview() {
 	initChoiceList()
 	initDraw()
 
+	//本文を取得する。取得できなければしおりを動かさずにエラー画面へ
+	//前書きと後書きの開閉で画面を作り直す時は取得済みの本文を使う
+	if view.story == nil {
+		backToTop := func() {
+			view.ncode = ""
+			view.currentnum = 0
+			SetView(noveltopView)
+		}
+		novel, num := view.novelStories, view.currentnum
+		var story *episode
+		startFetch(view.storyInfo.subTitle+"を取得中。", func(ctx context.Context) (err error) {
+			story, err = novel.getStory(ctx, num)
+			return err
+		}, func(err error) {
+			if err != nil {
+				showError(view.storyInfo.subTitle+"を取得できませんでした", err, func() { SetView(view) }, backToTop)
+				return
+			}
+			view.story = story
+			SetView(view)
+		}, backToTop)
+		return
+	}
+	story := view.story
+
+	//しおりを挟む
+	novelLibrary.setBookmark(view.ncode, view.currentnum, view.startLine, view.startParagraph)
+	saveBookmark := func() {
+		if err := novelLibrary.save(); err != nil {
+			drawLine("しおりの保存に失敗しました："+err.Error(), 0, height-1, defaultFg, defaultBg)
+		}
+	}
+
 	//Escキーを押したときの動作
 	doCancel := func() {
+		saveBookmark()
 		view.ncode = ""
 		view.currentnum = 0
 		SetView(noveltopView)
 	}
 
-	nextPage := func() {
-		nextViewer := novelview{}
-		nextViewer.ncode = view.novelInfo.ncode
-		nextViewer.currentnum = view.currentnum + 1 //閲覧話数をセット
-		nextViewer.novelInfo = view.novelInfo
-		nextViewer.novelStories = view.novelStories
-		nextViewer.storyInfo = &noveltopView.storiesIndex[nextViewer.currentnum]
-		novelviewerView = &nextViewer
+	//前後の話。削除された話は目次に無いので飛ばす
+	next, hasNext := view.storiesIndex.next(view.currentnum)
+	previous, hasPrevious := view.storiesIndex.previous(view.currentnum)
+	openStory := func(story storyInformation) {
+		saveBookmark()
+		storyViewer := novelview{}
+		storyViewer.ncode = view.novelInfo.ncode
+		storyViewer.currentnum = story.number //閲覧話数をセット
+		storyViewer.startParagraph = -1
+		storyViewer.novelInfo = view.novelInfo
+		storyViewer.novelStories = view.novelStories
+		storyViewer.storyInfo = &story
+		storyViewer.storiesIndex = view.storiesIndex
+		novelviewerView = &storyViewer
 		SetView(novelviewerView)
 	}
-
+	nextPage := func() {
+		openStory(next)
+	}
 	previousPage := func() {
-		previousViewer := novelview{}
-		previousViewer.ncode = view.novelInfo.ncode
-		previousViewer.currentnum = view.currentnum - 1 //閲覧話数をセット
-		previousViewer.novelInfo = view.novelInfo
-		previousViewer.novelStories = view.novelStories
-		previousViewer.storyInfo = &noveltopView.storiesIndex[previousViewer.currentnum]
-		novelviewerView = &previousViewer
-		SetView(novelviewerView)
+		openStory(previous)
 	}
 
 	viewer := NewMultiLineViewer()
 	var header []string //頭に追加する次のページとかの指示
-	viewerScreen := []string{
-		view.novelInfo.title,
-		view.storyInfo.chapterTitle,
-		"作者：" + view.novelInfo.author,
-		strconv.Itoa(view.currentnum) + "/" + strconv.Itoa(view.novelInfo.allcount),
-		stringJoinRow("=", width-8),
-		view.storyInfo.subTitle,
-		stringJoinRow("=", width-8),
-	}
-	viewerScreen = append(viewerScreen, view.novelStories.getStory(view.currentnum)...)
+	vertical := novelLibrary.settings.vertical
+	ruby := novelLibrary.settings.ruby
+	nextHeader, previousHeader := "次のページへ→", "←前のページへ"
+	if vertical {
+		//縦書きでは左へ読み進める
+		if ruby.mode == twoRowRuby {
+			ruby.mode = inlineRuby //読みを上の行に表示できないので文中に表示する
+		}
+		nextHeader, previousHeader = "←次のページへ", "前のページへ→"
+	}
+	notes := novelLibrary.settings.notes
+
+	position := "-" //目次に無い(削除された)話は何番目か分からない
+	if p := view.storiesIndex.position(view.currentnum); p >= 0 {
+		position = strconv.Itoa(p + 1)
+	}
+	episodeCount := position + "/" + strconv.Itoa(len(view.storiesIndex))
+	if view.novelInfo.isShort() {
+		episodeCount = "短編"
+	}
+
+	//表示する行を画面の大きさに合わせて作る。画面の大きさが変わった時は本文を取得し直さずに作り直す
+	//unitsは本文の各行の折り返しの単位で、ルビの親文字と読みを途中で折り返さないために使う
+	screenLines := func() (lines []string, units [][]foldUnit) {
+		lineWidth := width - 8 //区切り線の長さ
+		if vertical {
+			lineWidth = height - 1 //縦書きでは一列の長さに合わせる。最下行は現在位置の表示に使う
+		}
+		viewerScreen := append([]string{}, header...)
+		viewerScreen = append(viewerScreen,
+			view.novelInfo.title,
+			view.storyInfo.chapterTitle,
+			"作者："+view.novelInfo.author,
+			episodeCount,
+			stringJoinRow("=", lineWidth),
+			view.storyInfo.subTitle,
+			stringJoinRow("=", lineWidth),
+		)
+		units = make([][]foldUnit, len(viewerScreen))
+		if story != nil {
+			el, eu := episodeScreenLines(story, notes, ruby, view.notesOpen, lineWidth)
+			viewerScreen = append(viewerScreen, el...)
+			units = append(units, eu...)
+		}
+		return viewerScreen, units
+	}
 	viewer.Init()
+	viewer.SetVertical(vertical)
+	if view.novelInfo.isShort() {
+		viewer.SetStatus(episodeCount)
+	} else {
+		viewer.SetStatus(episodeCount + "話")
+	}
 	viewer.CancelSetting(doCancel)
-	if view.currentnum == 1 && view.novelInfo.allcount == 1 {
+	if notes == foldNotes && story != nil && (story.preface != nil || story.afterword != nil) {
+		//Enterキーで前書きと後書きを開閉する
+		viewer.SetEnterFunc(func() {
+			view.notesOpen = !view.notesOpen
+			view.startLine = viewer.CurrentLine()
+			view.startParagraph = -1 //開閉で段落の番号がずれるので行で合わせる
+			SetView(view)
+		})
+	}
+	//章が変わる場合は移動先の章名を添える
+	if chapter := chapterChange(*view.storyInfo, next); chapter != "" {
+		nextHeader += "　" + chapter
+	}
+	if chapter := chapterChange(*view.storyInfo, previous); chapter != "" {
+		previousHeader += "　" + chapter
+	}
+	if !hasNext && !hasPrevious {
 		//全一話のときは使うことが出来ない
 		viewer.SetLeftRightFunc(func() {}, func() {})
 		header = []string{""} //ヘッダーに指示なし
 
-	} else if view.currentnum == 1 {
+	} else if !hasPrevious {
 		//第一話目の時は前のページに戻れない
 		viewer.SetLeftRightFunc(func() {}, nextPage)
-		header = []string{"次のページへ→"}
+		header = []string{nextHeader}
 
-	} else if view.novelInfo.allcount == view.currentnum {
+	} else if !hasNext {
 		//最終話の時は次のページへ進めない
 		viewer.SetLeftRightFunc(previousPage, func() {})
-		header = []string{"←前のページへ"}
+		header = []string{previousHeader}
 
 	} else {
 		//前後のページへの遷移
 		viewer.SetLeftRightFunc(previousPage, nextPage)
-		header = []string{"次のページへ→", "←前のページへ"}
+		header = []string{nextHeader, previousHeader}
 	}
-	viewerScreen = append(header, viewerScreen...)
-	viewer.SetStrings(viewerScreen)
+	lines, units := screenLines()
+	viewer.SetFoldUnits(units)
+	viewer.SetStrings(lines)
+	if view.startParagraph >= 0 {
+		viewer.SetCurrentParagraph(view.startParagraph) //しおりを挟んだ時と画面の幅が違っても同じ段落から表示する
+	} else {
+		viewer.SetCurrentLine(view.startLine)
+	}
+	viewer.SetMoveFunc(func(line int) {
+		view.startLine = line
+		view.startParagraph = viewer.CurrentParagraph()
+		novelLibrary.setBookmark(view.ncode, view.currentnum, line, view.startParagraph)
+	})
+	SetResizeFunction(func() {
+		//同じ段落を表示したまま折り返し直す
+		initDraw()
+		lines, units := screenLines()
+		viewer.SetFoldUnits(units)
+		viewer.Resize(lines)
+		viewer.Draw()
+	})
 	viewer.Draw()
 }
+
+//episodeScreenLines 各話を表示する行に変換する。前書きと後書きは区切り線で本文と分け、設定に応じて表示、非表示、畳んで表示する
+//unitsは各行の折り返しの単位で、段落以外の行はnilになる
+func episodeScreenLines(story *episode, notes notesDisplay, ruby rubyStyle, open bool, w int) (lines []string, units [][]foldUnit) {
+	addLine := func(l string) {
+		lines = append(lines, l)
+		units = append(units, nil)
+	}
+	addParagraphs := func(paragraphs []paragraph) {
+		pl, pu := paragraphLines(paragraphs, ruby, w)
+		lines = append(lines, pl...)
+		units = append(units, pu...)
+	}
+	addNotes := func(name string, notesParagraphs []paragraph) {
+		if notesParagraphs == nil || notes == hideNotes {
+			return
+		}
+		switch {
+		case notes == foldNotes && !open:
+			addLine("【" + name + "】(Enterキーで開く)")
+			return
+		case notes == foldNotes:
+			addLine("【" + name + "】(Enterキーで閉じる)")
+		default:
+			addLine("【" + name + "】")
+		}
+		addParagraphs(notesParagraphs)
+	}
+	addNotes("前書き", story.preface)
+	if len(lines) > 0 {
+		addLine(stringJoinRow("-", w))
+	}
+	addParagraphs(story.honbun)
+	if story.afterword != nil && notes != hideNotes {
+		addLine(stringJoinRow("-", w))
+		addNotes("後書き", story.afterword)
+	}
+	return lines, units
+}
+
+//startFetch 取得中の表示をして、fetchを入力を処理するgoroutineの外で実行する
+//取得が終わるとdoneを入力を処理するgoroutineで呼ぶ。取得中にEscキーを押すと取得を中断してbackを呼び、doneは呼ばない
+//fetchの中では画面や小説の一覧に触らず、結果はdoneで反映する
+func startFetch(message string, fetch func(ctx context.Context) error, done func(err error), back func()) {
+	ctx, cancel := context.WithCancel(appContext)
+	cancelled := false
+	drawMessage := func() {
+		initDraw()
+		drawLine(message, 0, 0, defaultFg, defaultBg)
+		drawLine("Escキーで中断します", 0, 1, defaultFg, defaultBg)
+	}
+	drawMessage()
+	SetResizeFunction(drawMessage)
+	SetKeyActions(map[keyAction]func(){
+		actionCancel: func() {
+			cancelled = true
+			cancel()
+			back()
+		},
+	})
+	go func() {
+		err := fetch(ctx)
+		runOnUI(func() {
+			cancel()
+			if !cancelled {
+				done(err)
+			}
+		})
+	}()
+}
+
+//showError 取得に失敗した理由をエラー画面に表示する。retryは再試行する時、backは戻る時に呼ばれる
+func showError(title string, err error, retry, back func()) {
+	errorView.title = title
+	errorView.err = err
+	errorView.retry = retry
+	errorView.back = back
+	SetView(errorView)
+}
+
+//エラー画面
+func (view *errorview) turnview() {
+	//画面構成定義
+	initChoiceList()
+	initDraw()
+
+	setStrings([]string{"再試行する"})
+	setExecute(func(int) {
+		initDraw()
+		drawLine("再試行中。", 0, 0, defaultFg, defaultBg)
+		view.retry()
+	})
+	cancelSetting(true, "戻る", view.back)
+	setPattern(pat3)
+	setSection(7, height-7)
+
+	//描画
+	drawLine("なろうが読みたい！", 0, 0, defaultFg, defaultBg)
+	drawRow("=", 1, defaultFg, defaultBg)
+	drawLine(view.title, 0, 2, defaultFg, defaultBg)
+	drawLine(view.err.Error(), 0, 3, defaultFg, defaultBg)
+	drawLine(fetchErrorHint(view.err), 0, 4, defaultFg, defaultBg)
+	if pageURL := fetchErrorURL(view.err); pageURL != "" {
+		drawLine("URL："+pageURL, 0, 5, defaultFg, defaultBg)
+	}
+	drawRow("=", 6, defaultFg, defaultBg)
+	drawChoiceList()
+}
@@ -0,0 +1,192 @@
+package main
+
+//MultiLineViewerの本文内の検索
+//一致した位置は折り返す前の行の中のバイト数で持ち、描画する時に折り返した行(縦書きでは列)の中の位置に直す
+//そのため折り返しを跨いだ一致も見つけられ、画面の大きさが変わっても探し直す必要が無い
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+var (
+	searchMatchFg  = termbox.ColorBlack  //検索に一致した文字列の文字の色
+	searchMatchBg  = termbox.ColorYellow //検索に一致した文字列の背景の色
+	currentMatchFg = termbox.ColorBlack  //選択中の一致の文字の色
+	currentMatchBg = termbox.ColorCyan   //選択中の一致の背景の色
+)
+
+//viewerSearch 本文内の検索の状態
+type viewerSearch struct {
+	query    string        //検索する文字列。空なら検索していない
+	backward bool          //前へ向かって検索するならtrue
+	matches  []searchMatch //一致した位置
+	current  int           //選択中の一致。選択していなければ-1
+}
+
+//searchMatch 検索に一致した位置
+type searchMatch struct {
+	line  int //折り返す前の何番目の行か
+	start int //行の中の開始位置(バイト数)
+	end   int //行の中の終了位置(バイト数)
+}
+
+//highlight 折り返した行(縦書きでは列)の中で色を変える範囲
+type highlight struct {
+	start int //行の中の開始位置(バイト数)
+	end   int //行の中の終了位置(バイト数)
+	fg    termbox.Attribute
+	bg    termbox.Attribute
+}
+
+//findMatches 各行から検索する文字列に一致する位置を探す
+func findMatches(lines []string, query string) []searchMatch {
+	matches := []searchMatch{}
+	if query == "" {
+		return matches
+	}
+	for i, l := range lines {
+		for offset := 0; ; {
+			index := strings.Index(l[offset:], query)
+			if index < 0 {
+				break
+			}
+			matches = append(matches, searchMatch{i, offset + index, offset + index + len(query)})
+			offset += index + len(query)
+		}
+	}
+	return matches
+}
+
+//rowHighlights 折り返した行(縦書きでは列)の中で検索に一致した範囲
+func (v *MultiLineViewer) rowHighlights(row int) []highlight {
+	highlights := []highlight{}
+	if row >= len(v.lineIndexes) {
+		return highlights
+	}
+	rowStart := v.lineOffsets[row]
+	rowEnd := rowStart + v.rowLength(row)
+	for i, m := range v.search.matches {
+		if m.line != v.lineIndexes[row] || m.end <= rowStart || m.start >= rowEnd {
+			continue
+		}
+		//行を跨ぐ一致はこの行に含まれる部分だけ色を変える
+		h := highlight{m.start - rowStart, m.end - rowStart, searchMatchFg, searchMatchBg}
+		if h.start < 0 {
+			h.start = 0
+		}
+		if h.end > rowEnd-rowStart {
+			h.end = rowEnd - rowStart
+		}
+		if i == v.search.current {
+			h.fg, h.bg = currentMatchFg, currentMatchBg
+		}
+		highlights = append(highlights, h)
+	}
+	return highlights
+}
+
+//matchRow 一致の始まる折り返した行(縦書きでは列)
+func (v *MultiLineViewer) matchRow(m searchMatch) int {
+	for row, index := range v.lineIndexes {
+		if index == m.line && v.lineOffsets[row] <= m.start && m.start < v.lineOffsets[row]+v.rowLength(row) {
+			return row
+		}
+	}
+	return 0
+}
+
+//startSearch 検索する文字列を最下行で入力する。入力するたびに検索を始めた位置から探し直し、一致した位置へ移動する
+func (v *MultiLineViewer) startSearch(backward bool) {
+	from := v.currentLine
+	previous := v.search
+	label := "/"
+	if backward {
+		label = "?"
+	}
+	v.input.Init(label, 0, v.height-1)
+	v.input.BackgroundSetting(v.drawPage)
+	v.input.ChangeSetting(func(str string) {
+		v.currentLine = from
+		v.search = viewerSearch{str, backward, findMatches(v.lines, str), -1}
+		v.searchFrom(from, backward)
+	})
+	v.input.EnterSetting(func(string) {
+		v.moveFunc(v.currentLine)
+		v.Draw()
+	})
+	v.input.CancelSetting(func() {
+		//検索を始める前の状態に戻す
+		v.search = previous
+		v.currentLine = from
+		v.Draw()
+	})
+	v.input.Draw()
+}
+
+//searchFrom 折り返した行(縦書きでは列)fromからbackwardの向きに最初の一致へ移動する。見つからなければ反対の端の一致へ移動する
+func (v *MultiLineViewer) searchFrom(from int, backward bool) {
+	if len(v.search.matches) == 0 {
+		return
+	}
+	if backward {
+		for i := len(v.search.matches) - 1; i >= 0; i-- {
+			if v.matchRow(v.search.matches[i]) < from {
+				v.showMatch(i)
+				return
+			}
+		}
+		v.showMatch(len(v.search.matches) - 1)
+		return
+	}
+	for i, m := range v.search.matches {
+		if v.matchRow(m) >= from {
+			v.showMatch(i)
+			return
+		}
+	}
+	v.showMatch(0)
+}
+
+//showMatch i番目の一致を選択し、画面に入っていなければ画面の中央に表示する
+func (v *MultiLineViewer) showMatch(i int) {
+	v.search.current = i
+	row := v.matchRow(v.search.matches[i])
+	if row < v.currentLine || row >= v.currentLine+v.page() {
+		v.SetCurrentLine(row - v.page()/2)
+	}
+}
+
+//nextMatch 検索した向きに次の一致へ移動する。reverseなら逆向きに移動する。端まで行ったら反対の端へ戻る
+func (v *MultiLineViewer) nextMatch(reverse bool) {
+	n := len(v.search.matches)
+	if n == 0 {
+		return
+	}
+	backward := v.search.backward != reverse
+	switch {
+	case v.search.current < 0 || v.search.current >= n:
+		v.searchFrom(v.currentLine, backward) //一致を選択していなければ表示中の位置から探す
+	case backward:
+		v.showMatch((v.search.current - 1 + n) % n)
+	default:
+		v.showMatch((v.search.current + 1) % n)
+	}
+	v.moveFunc(v.currentLine)
+	v.Draw()
+}
+
+//searchStatus 検索の状態の表示
+func (v *MultiLineViewer) searchStatus() string {
+	status := "検索：" + v.search.query
+	if len(v.search.matches) == 0 {
+		return status + " 見つかりません"
+	}
+	current := "-"
+	if v.search.current >= 0 && v.search.current < len(v.search.matches) {
+		current = strconv.Itoa(v.search.current + 1)
+	}
+	return status + " " + current + "/" + strconv.Itoa(len(v.search.matches))
+}